@@ -0,0 +1,152 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// apiStory is the JSON representation of a story on a front page. Ranks are
+// null if the story was not ranked on the corresponding page.
+type apiStory struct {
+	ID                         int     `json:"id"`
+	By                         string  `json:"by"`
+	Title                      string  `json:"title"`
+	URL                        string  `json:"url"`
+	Domain                     string  `json:"domain"`
+	SubmissionTime             int64   `json:"submissionTime"`
+	OriginalSubmissionTime     int64   `json:"originalSubmissionTime"`
+	AgeApprox                  int64   `json:"ageApprox"`
+	Score                      int     `json:"score"`
+	Comments                   int     `json:"comments"`
+	CumulativeUpvotes          int     `json:"cumulativeUpvotes"`
+	CumulativeExpectedUpvotes  float64 `json:"cumulativeExpectedUpvotes"`
+	UpvoteRate                 float64 `json:"upvoteRate"`
+	CumulativeComments         int     `json:"cumulativeComments"`
+	CumulativeExpectedComments float64 `json:"cumulativeExpectedComments"`
+	CommentRate                float64 `json:"commentRate"`
+	CommentsPerUpvote          float64 `json:"commentsPerUpvote"`
+	TopRank                    *int32  `json:"topRank"`
+	QNRank                     *int32  `json:"qnRank"`
+	RawRank                    *int32  `json:"rawRank"`
+	RankDiff                   int32   `json:"rankDiff"`
+	Job                        bool    `json:"job"`
+	Flagged                    bool    `json:"flagged"`
+	Dupe                       bool    `json:"dupe"`
+}
+
+// apiFrontPageParams uses the same names as the URL parameters accepted by
+// the front page routes, so clients can round-trip them.
+type apiFrontPageParams struct {
+	PriorWeight        float64 `json:"priorWeight"`
+	OverallPriorWeight float64 `json:"overallPriorWeight"`
+	FatigueFactor      float64 `json:"fatigueFactor"`
+	Gravity            float64 `json:"gravity"`
+	PenaltyWeight      float64 `json:"penaltyWeight"`
+	PastTime           int64   `json:"pastTime"`
+}
+
+type apiFrontPage struct {
+	Ranking        string             `json:"ranking"`
+	SampleTime     int64              `json:"sampleTime"`
+	AttentionModel string             `json:"attentionModel"`
+	Params         apiFrontPageParams `json:"params"`
+	AverageAge     float64            `json:"averageAge"`
+	AverageQuality float64            `json:"averageQuality"`
+	AverageUpvotes float64            `json:"averageUpvotes"`
+	Stories        []apiStory         `json:"stories"`
+}
+
+func nullableRank(rank sql.NullInt32) *int32 {
+	if !rank.Valid {
+		return nil
+	}
+	r := rank.Int32
+	return &r
+}
+
+func newAPIStory(s Story) apiStory {
+	return apiStory{
+		ID:                         s.ID,
+		By:                         s.By,
+		Title:                      s.Title,
+		URL:                        s.URL,
+		Domain:                     s.Domain(),
+		SubmissionTime:             s.SubmissionTime,
+		OriginalSubmissionTime:     s.OriginalSubmissionTime,
+		AgeApprox:                  s.AgeApprox,
+		Score:                      s.Score,
+		Comments:                   s.Comments,
+		CumulativeUpvotes:          s.CumulativeUpvotes,
+		CumulativeExpectedUpvotes:  s.CumulativeExpectedUpvotes,
+		UpvoteRate:                 s.UpvoteRate,
+		CumulativeComments:         s.CumulativeComments,
+		CumulativeExpectedComments: s.CumulativeExpectedComments,
+		CommentRate:                s.CommentRate,
+		CommentsPerUpvote:          s.CommentsPerUpvote(),
+		TopRank:                    nullableRank(s.TopRank),
+		QNRank:                     nullableRank(s.QNRank),
+		RawRank:                    nullableRank(s.RawRank),
+		RankDiff:                   s.RankDiff(),
+		Job:                        s.Job,
+		Flagged:                    s.Flagged,
+		Dupe:                       s.Dupe,
+	}
+}
+
+func newAPIFrontPage(d frontPageData, sampleTime int64) apiFrontPage {
+	p := d.Params
+
+	stories := make([]apiStory, len(d.Stories))
+	for i, s := range d.Stories {
+		stories[i] = newAPIStory(s.Story)
+	}
+
+	return apiFrontPage{
+		Ranking:        d.Ranking,
+		SampleTime:     sampleTime,
+		AttentionModel: defaultAttentionModel.Name,
+		Params: apiFrontPageParams{
+			PriorWeight:        p.PriorWeight,
+			OverallPriorWeight: p.OverallPriorWeight,
+			FatigueFactor:      p.FatigueFactor,
+			Gravity:            p.Gravity,
+			PenaltyWeight:      p.PenaltyWeight,
+			PastTime:           p.PastTime,
+		},
+		AverageAge:     d.AverageAge,
+		AverageQuality: d.AverageQuality,
+		AverageUpvotes: d.AverageUpvotes,
+		Stories:        stories,
+	}
+}
+
+// frontpageAPIHandler serves the same stories as frontpageHandler, as JSON.
+func (app app) frontpageAPIHandler(ranking string) func(http.ResponseWriter, *http.Request, OptionalFrontPageParams) error {
+	return func(w http.ResponseWriter, r *http.Request, params OptionalFrontPageParams) error {
+		p := params.WithDefaults()
+
+		sampleTime, err := app.frontPageSampleTime(p)
+		if err != nil {
+			return errors.Wrap(err, "frontPageSampleTime")
+		}
+
+		// The API is not personalized, so don't pass a userID
+		d, err := app.getFrontPageData(r.Context(), ranking, p, sampleTime, sql.NullInt64{})
+		if err != nil {
+			return errors.Wrap(err, "getFrontPageData")
+		}
+
+		b, err := json.Marshal(newAPIFrontPage(d, sampleTime))
+		if err != nil {
+			return errors.Wrap(err, "marshaling front page JSON")
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		_, err = w.Write(b)
+		return errors.Wrap(err, "writing HTTP response")
+	}
+}
@@ -15,11 +15,32 @@ import (
 
 type app struct {
 	ndb                newsDatabase
-	hnClient           *hn.Client
+	rankSource         RankSource
+	storyScraper       StoryScraper
 	httpClient         *http.Client
 	logger             *slog.Logger
 	cacheSize          int
 	archiveTriggerChan chan context.Context
+	rankingFormulas    []rankingFormula
+	crawlDepths        crawlDepths
+
+	// caches the API items of stories that weren't scraped. nil when
+	// replaying captured crawls, which include the cached items.
+	itemCache *itemCache
+
+	// fields that parse less often than this raise an alert (see
+	// recordParseHealth)
+	parseHealthThreshold float64
+
+	// the password of the admin pages (see requireAdmin)
+	adminPassword string
+
+	// if set, the inputs of every crawl are recorded here (see crawlCapture)
+	captureDir string
+
+	// if set, used as the sampleTime of the next crawl instead of the current
+	// time. Set by the crawl scheduler, and when replaying captured crawls.
+	sampleTime int64
 }
 
 func initApp() app {
@@ -53,6 +74,13 @@ func initApp() app {
 	}
 	logger.Info("Database opened successfully")
 
+	model, err := db.initAttentionModel(os.Getenv("ATTENTION_MODEL_FILE"), os.Getenv("ATTENTION_MODEL"))
+	if err != nil {
+		LogFatal(logger, "initAttentionModel", err)
+	}
+	setAttentionModel(model)
+	logger.Info("Using attention model", "name", model.Name)
+
 	logger.Info("Initializing HTTP client")
 	retryClient := retryablehttp.NewClient()
 	retryClient.RetryMax = 3
@@ -63,17 +91,63 @@ func initApp() app {
 
 	httpClient := retryClient.StandardClient()
 
-	hnClient := hn.NewClient(httpClient)
+	var rankSource RankSource = hn.NewClient(httpClient)
+	var storyScraper StoryScraper = httpStoryScraper{client: httpClient}
+
+	// Crawl recorded data instead of Hacker News, e.g. for offline development and staging
+	if fixturesDir := os.Getenv("HN_FIXTURES_DIR"); fixturesDir != "" {
+		logger.Info("Crawling fixtures instead of Hacker News", "dir", fixturesDir)
+		fixtures := fixtureSource{dir: fixturesDir}
+		rankSource = fixtures
+		storyScraper = fixtures
+	}
+
+	var rankingFormulas []rankingFormula
+	if filename := os.Getenv("RANKING_FORMULAS_FILE"); filename != "" {
+		rankingFormulas, err = loadRankingFormulas(filename)
+		if err == nil {
+			err = db.validateRankingFormulas(rankingFormulas)
+		}
+		if err != nil {
+			LogFatal(logger, "loadRankingFormulas", err)
+		}
+		logger.Info("Loaded ranking formulas", "file", filename, "formulas", len(rankingFormulas))
+	}
+
+	crawlDepths, err := parseCrawlDepths(os.Getenv("CRAWL_DEPTH"))
+	if err != nil {
+		LogFatal(logger, "CRAWL_DEPTH", err)
+	}
+	if crawlDepths.maxRanks() > defaultCrawlDepth {
+		logger.Info("Crawling beyond the ranks the attention model was fitted on. Expected upvotes for deeper ranks are extrapolated", "crawlDepth", os.Getenv("CRAWL_DEPTH"), "fittedRanks", defaultCrawlDepth)
+	}
+
+	parseHealthThreshold, err := parseParseHealthThreshold(os.Getenv("PARSE_HEALTH_THRESHOLD"))
+	if err != nil {
+		LogFatal(logger, "PARSE_HEALTH_THRESHOLD", err)
+	}
+
+	captureDir := os.Getenv("CAPTURE_DIR")
+	if captureDir != "" {
+		logger.Info("Recording crawl inputs", "dir", captureDir)
+	}
 
 	logger.Info("Application initialization complete")
 
 	return app{
-		httpClient:         httpClient,
-		hnClient:           hnClient,
-		logger:             logger,
-		ndb:                db,
-		cacheSize:          cacheSize,
-		archiveTriggerChan: make(chan context.Context, 1), // Buffer size 1: one signal can queue while processing
+		httpClient:           httpClient,
+		rankSource:           rankSource,
+		storyScraper:         storyScraper,
+		logger:               logger,
+		ndb:                  db,
+		cacheSize:            cacheSize,
+		captureDir:           captureDir,
+		rankingFormulas:      rankingFormulas,
+		crawlDepths:          crawlDepths,
+		parseHealthThreshold: parseHealthThreshold,
+		adminPassword:        os.Getenv("ADMIN_PASSWORD"),
+		itemCache:            newItemCache(),
+		archiveTriggerChan:   make(chan context.Context, 1), // Buffer size 1: one signal can queue while processing
 	}
 }
 
@@ -18,7 +18,10 @@ type ArchiveData struct {
 	RanksPlotData   [][]any `json:"RanksPlotData"`
 	UpvotesPlotData [][]any `json:"UpvotesPlotData"`
 	MaxSampleTime   int     `json:"MaxSampleTime"`
-	Story                   // embed Story
+	// domain penalty in the story's latest crawl. Archives written before
+	// this was added don't have it, so it reads as 0 (no penalty shown).
+	Penalty float64 `json:"Penalty"`
+	Story           // embed Story
 }
 
 func (app app) generateArchiveJSON(ctx context.Context, storyID int) ([]byte, error) {
@@ -43,6 +46,11 @@ func (app app) generateArchiveJSON(ctx context.Context, storyID int) ([]byte, er
 		return nil, errors.Wrap(err, "upvotesDatapoints")
 	}
 
+	penalty, err := latestPenalty(ctx, ndb, storyID)
+	if err != nil {
+		return nil, errors.Wrap(err, "latestPenalty")
+	}
+
 	// Fetch Story details
 	s, err := ndb.selectStoryDetails(ctx, storyID)
 	if err != nil {
@@ -54,6 +62,7 @@ func (app app) generateArchiveJSON(ctx context.Context, storyID int) ([]byte, er
 		RanksPlotData:   ranksPlotData,
 		UpvotesPlotData: upvotesPlotData,
 		MaxSampleTime:   maxSampleTime,
+		Penalty:         penalty,
 		Story:           s,
 	}
 
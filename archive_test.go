@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestArchiveDataPenalty(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		want float64
+	}{
+		{name: "with penalty", json: `{"MaxSampleTime": 1000, "Penalty": 0.25, "ID": 1, "Archived": true}`, want: 0.25},
+		// archives written before the penalty was saved
+		{name: "without penalty", json: `{"MaxSampleTime": 1000, "ID": 1, "Archived": true}`, want: 0},
+	}
+
+	for _, tt := range tests {
+		var d ArchiveData
+		if err := json.Unmarshal([]byte(tt.json), &d); err != nil {
+			t.Fatalf("%s: Unmarshal returned error: %v", tt.name, err)
+		}
+		if d.Penalty != tt.want {
+			t.Errorf("%s: Penalty = %f, want %f", tt.name, d.Penalty, tt.want)
+		}
+		if d.ID != 1 || !d.Archived {
+			t.Errorf("%s: story = %+v, want the embedded story", tt.name, d.Story)
+		}
+	}
+
+	// the penalty survives a round trip through an archive
+	b, err := json.Marshal(ArchiveData{Penalty: 0.5, Story: Story{ID: 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d ArchiveData
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Penalty != 0.5 || d.ID != 2 {
+		t.Errorf("round trip = penalty %f, story %d, want 0.5, 2", d.Penalty, d.ID)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Attention models are registered in the attention_models table, and the
+// name of the model used to compute each datapoint is stored in
+// dataset.attentionModel. So the parameters behind any historical value of
+// cumulativeExpectedUpvotes can always be looked up, even after the active
+// model has changed. Registered models never change: to change the
+// coefficients, register a model with a new name.
+
+// loadAttentionModels reads a JSON array of attention models, for example:
+//
+//	[{
+//	  "name": "2024-05",
+//	  "coefficients": {"top": [-2.886938, -3.316492, -0.5193376], "new": [...], ...},
+//	  "fatigueFactor": 0.003462767,
+//	  "priorWeight": 0.75
+//	}]
+func loadAttentionModels(filename string) ([]attentionModel, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", filename)
+	}
+
+	var models []attentionModel
+	if err = json.Unmarshal(b, &models); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", filename)
+	}
+
+	names := make(map[string]bool, len(models))
+	for _, m := range models {
+		if m.Name == "" {
+			return nil, fmt.Errorf("%s: attention model without a name", filename)
+		}
+		if names[m.Name] {
+			return nil, fmt.Errorf("%s: duplicate attention model %s", filename, m.Name)
+		}
+		if m.FatigueFactor <= 0 || m.PriorWeight < 0 {
+			return nil, fmt.Errorf("%s: attention model %s: fatigueFactor must be positive and priorWeight must not be negative", filename, m.Name)
+		}
+		names[m.Name] = true
+	}
+
+	return models, nil
+}
+
+// saveAttentionModel registers m. It is an error to register a model with
+// the name of an existing model but different parameters.
+func (ndb newsDatabase) saveAttentionModel(m attentionModel) error {
+	coefficientsJSON, err := json.Marshal(m.Coefficients)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+
+	existing, err := ndb.selectAttentionModel(m.Name)
+	if err == nil {
+		if existing != m {
+			return fmt.Errorf("attention model %s is already registered with different parameters. Use a new name", m.Name)
+		}
+		return nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	_, err = ndb.db.Exec(`
+		insert into attention_models(name, coefficients, fatigueFactor, priorWeight, created)
+		values (?, ?, ?, ?, ?)
+	`, m.Name, string(coefficientsJSON), m.FatigueFactor, m.PriorWeight, time.Now().Unix())
+
+	return errors.Wrapf(err, "inserting attention model %s", m.Name)
+}
+
+func (ndb newsDatabase) selectAttentionModel(name string) (attentionModel, error) {
+	m := attentionModel{Name: name}
+
+	var coefficientsJSON string
+	err := ndb.db.QueryRow(`
+		select coefficients, fatigueFactor, priorWeight
+		from attention_models
+		where name = ?
+	`, name).Scan(&coefficientsJSON, &m.FatigueFactor, &m.PriorWeight)
+	if err != nil {
+		return m, errors.Wrapf(err, "selecting attention model %s", name)
+	}
+
+	err = json.Unmarshal([]byte(coefficientsJSON), &m.Coefficients)
+	return m, errors.Wrapf(err, "parsing coefficients of attention model %s", name)
+}
+
+// initAttentionModel registers the builtin model and the models in
+// modelsFile (if not empty), and returns the registered model called name.
+// An empty name selects the builtin model.
+func (ndb newsDatabase) initAttentionModel(modelsFile string, name string) (attentionModel, error) {
+	models := []attentionModel{builtinAttentionModel}
+
+	if modelsFile != "" {
+		fileModels, err := loadAttentionModels(modelsFile)
+		if err != nil {
+			return builtinAttentionModel, err
+		}
+		models = append(models, fileModels...)
+	}
+
+	for _, m := range models {
+		if err := ndb.saveAttentionModel(m); err != nil {
+			return builtinAttentionModel, err
+		}
+	}
+
+	if name == "" {
+		return builtinAttentionModel, nil
+	}
+
+	return ndb.selectAttentionModel(name)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testCoefficientsJSON = `{
+	"top": [-2.886938, -3.316492, -0.5193376],
+	"new": [-5.856364, -2.564690, -0.3937709],
+	"best": [-7.175409, -1.280364, -0.3717084],
+	"ask": [-5.316879, -5.469948, -1.2944215],
+	"show": [-6.292276, -5.912105, -1.1996512]
+}`
+
+func TestLoadAttentionModels(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			json: `[
+				{"name": "a", "coefficients": ` + testCoefficientsJSON + `, "fatigueFactor": 0.003, "priorWeight": 0.75},
+				{"name": "b", "coefficients": ` + testCoefficientsJSON + `, "fatigueFactor": 0.004, "priorWeight": 0}
+			]`,
+			want: 2,
+		},
+		{
+			name:    "missing page type",
+			json:    `[{"name": "a", "coefficients": {"top": [-2.9, -3.3, -0.5]}, "fatigueFactor": 0.003, "priorWeight": 0.75}]`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong number of coefficients",
+			json:    `[{"name": "a", "coefficients": {"top": "x"}, "fatigueFactor": 0.003, "priorWeight": 0.75}]`,
+			wantErr: true,
+		},
+		{
+			name:    "no name",
+			json:    `[{"coefficients": ` + testCoefficientsJSON + `, "fatigueFactor": 0.003, "priorWeight": 0.75}]`,
+			wantErr: true,
+		},
+		{
+			name: "duplicate name",
+			json: `[
+				{"name": "a", "coefficients": ` + testCoefficientsJSON + `, "fatigueFactor": 0.003, "priorWeight": 0.75},
+				{"name": "a", "coefficients": ` + testCoefficientsJSON + `, "fatigueFactor": 0.004, "priorWeight": 0.75}
+			]`,
+			wantErr: true,
+		},
+		{
+			name:    "no fatigue factor",
+			json:    `[{"name": "a", "coefficients": ` + testCoefficientsJSON + `, "priorWeight": 0.75}]`,
+			wantErr: true,
+		},
+		{
+			name:    "negative prior weight",
+			json:    `[{"name": "a", "coefficients": ` + testCoefficientsJSON + `, "fatigueFactor": 0.003, "priorWeight": -1}]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "models.json")
+		if err := os.WriteFile(filename, []byte(tt.json), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		models, err := loadAttentionModels(filename)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: loadAttentionModels returned no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: loadAttentionModels returned error: %v", tt.name, err)
+			continue
+		}
+		if len(models) != tt.want {
+			t.Errorf("%s: loaded %d models, want %d", tt.name, len(models), tt.want)
+		}
+	}
+}
+
+func TestPageTypeCoefficientsJSON(t *testing.T) {
+	b, err := builtinAttentionModel.Coefficients.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cs pageTypeCoefficients
+	if err := cs.UnmarshalJSON(b); err != nil {
+		t.Fatal(err)
+	}
+	if cs != builtinAttentionModel.Coefficients {
+		t.Errorf("coefficients after a JSON round trip = %v, want %v", cs, builtinAttentionModel.Coefficients)
+	}
+}
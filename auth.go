@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/subtle"
 	"database/sql"
 	"math/rand"
 	"net/http"
@@ -98,3 +99,15 @@ func setUserIDCookie(w http.ResponseWriter, userID sql.NullInt64) {
 	// containing the necessary cookie data.
 	http.SetCookie(w, &cookie)
 }
+
+// requireAdmin returns an error unless the request is authenticated with
+// HTTP basic auth and the password set in ADMIN_PASSWORD. Admin pages are
+// disabled if ADMIN_PASSWORD isn't set.
+func (app app) requireAdmin(w http.ResponseWriter, r *http.Request) error {
+	_, password, ok := r.BasicAuth()
+	if app.adminPassword == "" || !ok || subtle.ConstantTimeCompare([]byte(password), []byte(app.adminPassword)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="admin", charset="UTF-8"`)
+		return httperror.PublicErrorf(http.StatusUnauthorized, "admin password required")
+	}
+	return nil
+}
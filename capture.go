@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/johnwarden/hn"
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// A crawlCapture records the raw inputs of a single crawl (API rank lists,
+// item details, and scraped HTML) into a directory named after the crawl's
+// sampleTime. The files use the same layout as fixtureSource, gzipped, so a
+// capture directory can be crawled again using fixtureSource.
+type crawlCapture struct {
+	dir    string
+	logger *slog.Logger
+}
+
+func newCrawlCapture(captureDir string, sampleTime int64, logger *slog.Logger) crawlCapture {
+	return crawlCapture{
+		dir:    filepath.Join(captureDir, fmt.Sprintf("%d", sampleTime)),
+		logger: logger,
+	}
+}
+
+// save writes a gzipped file into the capture directory. Errors are only
+// logged: a failed capture should never fail the crawl itself.
+func (c crawlCapture) save(name string, content []byte) {
+	err := func() error {
+		filename := filepath.Join(c.dir, name+".gz")
+
+		if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+			return errors.Wrap(err, "creating capture directory")
+		}
+
+		var buf bytes.Buffer
+		gzipWriter := gzip.NewWriter(&buf)
+		if _, err := gzipWriter.Write(content); err != nil {
+			return errors.Wrap(err, "compressing capture")
+		}
+		if err := gzipWriter.Close(); err != nil {
+			return errors.Wrap(err, "compressing capture")
+		}
+
+		return errors.Wrap(os.WriteFile(filename, buf.Bytes(), 0o644), "writing capture")
+	}()
+	if err != nil {
+		c.logger.Error("Failed to save crawl capture", err, "file", name)
+	}
+}
+
+func (c crawlCapture) saveJSON(name string, v any) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		c.logger.Error("Failed to marshal crawl capture", err, "file", name)
+		return
+	}
+	c.save(name, b)
+}
+
+// recordingRankSource is a RankSource that saves everything it returns to a
+// crawlCapture.
+type recordingRankSource struct {
+	RankSource
+	capture crawlCapture
+}
+
+func (s recordingRankSource) Stories(ctx context.Context, pageType string) ([]int, error) {
+	ids, err := s.RankSource.Stories(ctx, pageType)
+	if err == nil {
+		s.capture.saveJSON(filepath.Join("api", pageType+"stories.json"), ids)
+	}
+	return ids, err
+}
+
+func (s recordingRankSource) GetItems(ctx context.Context, ids []int, maxGoroutines int) ([]hn.Item, error) {
+	items, err := s.RankSource.GetItems(ctx, ids, maxGoroutines)
+	s.saveItems(items)
+	return items, err
+}
+
+func (s recordingRankSource) saveItems(items []hn.Item) {
+	for _, item := range items {
+		// items that failed to download are left empty
+		if item.ID != 0 {
+			s.capture.saveJSON(filepath.Join("api", "item", fmt.Sprintf("%d.json", item.ID)), item)
+		}
+	}
+}
+
+func (s recordingRankSource) Updates(ctx context.Context) (*hn.Updates, error) {
+	updates, err := s.RankSource.Updates(ctx)
+	if err == nil {
+		s.capture.saveJSON(filepath.Join("api", "updates.json"), updates)
+	}
+	return updates, err
+}
+
+func (s recordingRankSource) MaxItem(ctx context.Context) (int, error) {
+	maxItem, err := s.RankSource.MaxItem(ctx)
+	if err == nil {
+		s.capture.saveJSON(filepath.Join("api", "maxitem.json"), maxItem)
+	}
+	return maxItem, err
+}
+
+// captureItems saves items that were not fetched from source, such as items
+// served from the itemCache, if source records a crawl capture.
+func captureItems(source RankSource, items []hn.Item) {
+	if s, ok := source.(recordingRankSource); ok {
+		s.saveItems(items)
+	}
+}
+
+// recordingStoryScraper is a StoryScraper that saves every page it fetches to
+// a crawlCapture.
+type recordingStoryScraper struct {
+	StoryScraper
+	capture crawlCapture
+}
+
+func (s recordingStoryScraper) FetchPage(ctx context.Context, path string) ([]byte, error) {
+	b, err := s.StoryScraper.FetchPage(ctx, path)
+	if err == nil {
+		s.capture.save(filepath.Join("html", fixtureFileName(path)), b)
+	}
+	return b, err
+}
+
+// withCapture returns a copy of app that records the inputs of the crawl at
+// sampleTime into app.captureDir.
+func (app app) withCapture(sampleTime int64) app {
+	capture := newCrawlCapture(app.captureDir, sampleTime, app.logger)
+	app.rankSource = recordingRankSource{app.rankSource, capture}
+	app.storyScraper = recordingStoryScraper{app.storyScraper, capture}
+	return app
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slog"
+)
+
+// A command is a command-line tool that is run instead of the server, as in
+// `news <command> [flags]`.
+type command struct {
+	description string
+	run         func(ctx context.Context, logger *slog.Logger, args []string) error
+}
+
+var commands = map[string]command{
+	"fit":       {"estimate attention model coefficients from the dataset", runFitCommand},
+	"recompute": {"recompute expected upvotes for the whole dataset", runRecomputeCommand},
+	"simulate":  {"re-rank historical crawls with a custom ranking formula", runSimulateCommand},
+	"replay":    {"re-run captured crawls into a fresh database", runReplayCommand},
+}
+
+// runCommand runs the command named by args[0] with the remaining args. It
+// returns false, without running anything, if args[0] isn't the name of a
+// command, so that other arguments don't keep the server from starting.
+func runCommand(args []string) bool {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return false
+	}
+
+	logger := newLogger(os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	if err := cmd.run(context.Background(), logger, args[1:]); err != nil {
+		LogFatal(logger, strings.Join(args, " "), err)
+	}
+	return true
+}
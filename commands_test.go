@@ -0,0 +1,12 @@
+package main
+
+import "testing"
+
+func TestRunCommandUnknown(t *testing.T) {
+	// arguments that aren't command names start the server instead
+	for _, args := range [][]string{{"serve"}, {"-port", "8080"}, {""}, {"Replay"}} {
+		if runCommand(args) {
+			t.Errorf("runCommand(%q) ran a command", args)
+		}
+	}
+}
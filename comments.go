@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// Comments are modeled like upvotes: the expected comments of a story are
+// the share of attention it received at its ranks (the same share as for
+// expected upvotes) times the sitewide comments per minute. So
+// cumulativeExpectedComments is the number of comments an average story
+// would have received at the same ranks and times, and the comment rate is
+// the ratio of actual to expected comments.
+//
+// Unlike upvotes, comments are not adjusted for fatigue, since the fatigue
+// factor was fitted on upvotes only.
+
+const (
+	// A story is marked as a flamewar when it has at least
+	// flamewarMinComments comments and more than flamewarRatio comments per
+	// upvote. This is roughly when HN's own flamewar detector kicks in.
+	flamewarMinComments = 40
+	flamewarRatio       = 1.0
+)
+
+func (p ModelParams) commentRate(comments int, expectedComments float64) float64 {
+	return (float64(comments) + p.PriorWeight) / (expectedComments + p.PriorWeight)
+}
+
+func (s Story) CommentRateString() string {
+	return fmt.Sprintf("%.2f", s.CommentRate)
+}
+
+// CommentsPerUpvote is the flamewar indicator: the number of comments per
+// upvote, not counting the submitter's own upvote.
+func (s Story) CommentsPerUpvote() float64 {
+	return float64(s.Comments) / float64(max(s.Score-1, 1))
+}
+
+func (s Story) CommentsPerUpvoteString() string {
+	return fmt.Sprintf("%.1f", s.CommentsPerUpvote())
+}
+
+func (s Story) IsFlamewar() bool {
+	return s.Comments >= flamewarMinComments && s.CommentsPerUpvote() > flamewarRatio
+}
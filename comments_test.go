@@ -0,0 +1,51 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCommentRate(t *testing.T) {
+	p := ModelParams{PriorWeight: 1}
+
+	tests := []struct {
+		comments         int
+		expectedComments float64
+		want             float64
+	}{
+		// without comments or attention, the rate is the prior of 1
+		{comments: 0, expectedComments: 0, want: 1},
+		{comments: 9, expectedComments: 9, want: 1},
+		{comments: 19, expectedComments: 4, want: 4},
+		{comments: 0, expectedComments: 9, want: 0.1},
+	}
+
+	for _, tt := range tests {
+		if got := p.commentRate(tt.comments, tt.expectedComments); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("commentRate(%d, %f) = %f, want %f", tt.comments, tt.expectedComments, got, tt.want)
+		}
+	}
+}
+
+func TestIsFlamewar(t *testing.T) {
+	tests := []struct {
+		score, comments int
+		want            bool
+	}{
+		{score: 100, comments: 50, want: false},
+		{score: 30, comments: 60, want: true},
+		// few comments, even if there are more comments than upvotes
+		{score: 2, comments: flamewarMinComments - 1, want: false},
+		{score: 1, comments: flamewarMinComments, want: true},
+		// as many comments as upvotes, not counting the submitter's
+		{score: 41, comments: 40, want: false},
+		{score: 40, comments: 40, want: true},
+	}
+
+	for _, tt := range tests {
+		s := Story{Score: tt.score, Comments: tt.comments}
+		if got := s.IsFlamewar(); got != tt.want {
+			t.Errorf("IsFlamewar() with %d upvotes and %d comments = %v, want %v", tt.score, tt.comments, got, tt.want)
+		}
+	}
+}
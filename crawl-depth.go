@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// defaultCrawlDepth is the number of ranks crawled on each page type unless
+// CRAWL_DEPTH says otherwise: the first nPages pages, which the builtin
+// attention model was fitted on.
+const defaultCrawlDepth = nPages * 30
+
+// crawlDepths is the number of ranks crawled on each page type. Zero means
+// defaultCrawlDepth.
+//
+// Deeper ranks are stored in the dataset like any other, and expected
+// upvotes for them are extrapolated by the attention model. Once enough
+// deep data has been collected, the fit command estimates coefficients
+// that cover the deeper pages too.
+type crawlDepths [nPageTypes]int
+
+// ranks returns the number of ranks to crawl on pageType.
+func (d crawlDepths) ranks(pageType pageTypeInt) int {
+	if d[pageType] == 0 {
+		return defaultCrawlDepth
+	}
+	return d[pageType]
+}
+
+// pages returns the number of pages to scrape on pageType.
+func (d crawlDepths) pages(pageType pageTypeInt) int {
+	return (d.ranks(pageType) + 29) / 30
+}
+
+// maxRanks returns the deepest rank crawled on any page type.
+func (d crawlDepths) maxRanks() int {
+	var result int
+	for pageType := top; pageType <= show; pageType++ {
+		result = max(result, d.ranks(pageType))
+	}
+	return result
+}
+
+// parseCrawlDepths parses the CRAWL_DEPTH setting, which is either a number
+// of ranks for all page types ("150"), or a comma-separated list of page
+// types and ranks ("new=500,top=150"). Page types that aren't listed are
+// crawled to defaultCrawlDepth.
+func parseCrawlDepths(s string) (crawlDepths, error) {
+	var d crawlDepths
+	if s == "" {
+		return d, nil
+	}
+
+	parseRanks := func(v string) (int, error) {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid crawl depth %q", v)
+		}
+		return n, nil
+	}
+
+	if !strings.Contains(s, "=") {
+		n, err := parseRanks(s)
+		if err != nil {
+			return d, err
+		}
+		for pageType := range d {
+			d[pageType] = n
+		}
+		return d, nil
+	}
+
+	for _, pair := range strings.Split(s, ",") {
+		name, v, _ := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+
+		pageType, ok := pageTypeByName(name)
+		if !ok {
+			return d, fmt.Errorf("unknown page type %q", name)
+		}
+
+		n, err := parseRanks(v)
+		if err != nil {
+			return d, err
+		}
+		d[pageType] = n
+	}
+
+	return d, nil
+}
+
+func pageTypeByName(name string) (pageTypeInt, bool) {
+	for pageType, pageTypeName := range pageTypes {
+		if pageTypeName == name {
+			return pageType, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+// crawlDepths are indexed by pageTypeInt: top, new, best, ask, show
+
+func TestParseCrawlDepths(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    crawlDepths
+		wantErr bool
+	}{
+		{s: "", want: crawlDepths{}},
+		{s: "150", want: crawlDepths{150, 150, 150, 150, 150}},
+		{s: " 60 ", want: crawlDepths{60, 60, 60, 60, 60}},
+		{s: "new=500", want: crawlDepths{0, 500}},
+		{s: "new=500,top=150", want: crawlDepths{150, 500}},
+		{s: "new = 500, show=30", want: crawlDepths{0, 500, 0, 0, 30}},
+		{s: "0", wantErr: true},
+		{s: "-30", wantErr: true},
+		{s: "deep", wantErr: true},
+		{s: "new=", wantErr: true},
+		{s: "new=0", wantErr: true},
+		{s: "jobs=30", wantErr: true},
+		{s: "new=30,150", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCrawlDepths(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCrawlDepths(%q) = %v, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCrawlDepths(%q) returned error: %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseCrawlDepths(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCrawlDepthsPages(t *testing.T) {
+	tests := []struct {
+		depths   crawlDepths
+		pageType pageTypeInt
+		ranks    int
+		pages    int
+	}{
+		{crawlDepths{}, top, defaultCrawlDepth, nPages},
+		{crawlDepths{0, 500}, new, 500, 17},
+		{crawlDepths{0, 500}, top, defaultCrawlDepth, nPages},
+		{crawlDepths{0, 0, 0, 30}, ask, 30, 1},
+		{crawlDepths{0, 0, 0, 31}, ask, 31, 2},
+	}
+
+	for _, tt := range tests {
+		if got := tt.depths.ranks(tt.pageType); got != tt.ranks {
+			t.Errorf("%v.ranks(%s) = %d, want %d", tt.depths, pageTypes[tt.pageType], got, tt.ranks)
+		}
+		if got := tt.depths.pages(tt.pageType); got != tt.pages {
+			t.Errorf("%v.pages(%s) = %d, want %d", tt.depths, pageTypes[tt.pageType], got, tt.pages)
+		}
+	}
+
+	if got := (crawlDepths{0, 500}).maxRanks(); got != 500 {
+		t.Errorf("maxRanks() = %d, want 500", got)
+	}
+	if got := (crawlDepths{0, 0, 0, 30}).maxRanks(); got != defaultCrawlDepth {
+		t.Errorf("maxRanks() = %d, want %d", got, defaultCrawlDepth)
+	}
+}
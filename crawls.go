@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The crawl scheduler plans a crawl on every minute mark. If a crawl
+// overruns the next minute mark, the missed ticks are skipped and the
+// next crawl starts right away, planned for the latest missed tick. Every
+// crawl is recorded in the crawls table, with its planned time, how late it
+// started, and the gap since the previous successful crawl.
+//
+// Normally upvotes and expected upvotes only accrue between crawls less than
+// maxElapsedTime apart. Longer gaps, after failed or overrunning crawls, are
+// interpolated up to maxInterpolatedGap (see gapUpvoteShare).
+
+const (
+	crawlInterval = 60
+
+	// Don't start a crawl with less than this many seconds before its
+	// deadline. Push the deadline to the following minute mark instead.
+	minCrawlTime = 20
+
+	// Gaps between crawls up to this long are interpolated. Beyond this,
+	// the ranks of a story at either end of the gap say little about where
+	// it was in between.
+	maxInterpolatedGap = 10 * 60
+)
+
+// crawlStats are the sitewide statistics of a crawl. Sitewide upvotes and
+// comments are per minute, as in crawl.
+type crawlStats struct {
+	SitewideUpvotes              float64
+	SitewideDeltaExpectedUpvotes float64
+	SitewideExpectedUpvotesShare float64
+	SitewideComments             float64
+	// the number of stories crawled, and where their details came from
+	Stories int
+	Scraped int
+	FromAPI int
+	// the number of stories whose upvotes were interpolated over a gap
+	Interpolated int
+	// the number of errors counted in errors_total{type="crawl"}
+	Errors         int
+	AttentionModel string
+	// the largest item ID checked by the submission tracker, and the number
+	// of submissions it recorded
+	MaxItem     int
+	Submissions int
+	// how well the scraped pages parsed, stored in the parse_health table
+	// (see recordParseHealth) rather than the crawls table
+	ParseReport parseReport
+}
+
+// A crawlRecord is one row of the crawls table. The stats of failed crawls
+// are stored as null.
+type crawlRecord struct {
+	SampleTime  int64
+	PlannedTime int64
+	// seconds since the previous successful crawl, or 0 if there is none
+	Gap         int64
+	MissedTicks int
+	Duration    time.Duration
+	Error       sql.NullString
+	crawlStats
+}
+
+func (c crawlRecord) Failed() bool {
+	return c.Error.Valid
+}
+
+func (c crawlRecord) Latency() int64 {
+	return c.SampleTime - c.PlannedTime
+}
+
+func (c crawlRecord) SampleTimeISOString() string {
+	return time.Unix(c.SampleTime, 0).UTC().Format("2006-01-02T15:04:05")
+}
+
+func (c crawlRecord) DurationString() string {
+	return fmt.Sprintf("%.1fs", c.Duration.Seconds())
+}
+
+func (c crawlRecord) SitewideUpvotesString() string {
+	return fmt.Sprintf("%.0f", c.SitewideUpvotes)
+}
+
+func (ndb newsDatabase) insertCrawl(ctx context.Context, c crawlRecord) error {
+	stats := []any{c.SitewideUpvotes, c.SitewideDeltaExpectedUpvotes, c.SitewideExpectedUpvotesShare, c.SitewideComments, c.Stories, c.Scraped, c.FromAPI, c.Interpolated, c.AttentionModel, c.MaxItem, c.Submissions}
+	if c.Failed() {
+		stats = make([]any, len(stats))
+	}
+
+	_, err := ndb.db.ExecContext(ctx, `
+		insert into crawls (
+			sampleTime, plannedTime, gap, missedTicks, duration, error, errors
+			, sitewideUpvotes, sitewideDeltaExpectedUpvotes, sitewideExpectedUpvotesShare, sitewideComments
+			, stories, scraped, fromAPI, interpolated, attentionModel, maxItem, submissions
+		)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		on conflict (sampleTime) do nothing
+	`, append([]any{c.SampleTime, c.PlannedTime, c.Gap, c.MissedTicks, c.Duration.Seconds(), c.Error, c.Errors}, stats...)...)
+	return errors.Wrap(err, "inserting crawl")
+}
+
+// crawlAndRecord crawls at app.sampleTime and records the crawl, planned at
+// plannedTime, in the crawls table.
+func (app app) crawlAndRecord(ctx context.Context, plannedTime int64, missedTicks int) error {
+	startTime := time.Now()
+
+	crawlLatency.Update(float64(app.sampleTime - plannedTime))
+
+	lastCrawlTime, err := app.ndb.selectLastCrawlTime()
+	if err != nil {
+		return errors.Wrap(err, "selectLastCrawlTime")
+	}
+	var gap int64
+	if lastCrawlTime != 0 {
+		gap = app.sampleTime - int64(lastCrawlTime)
+		crawlGap.Update(float64(gap))
+	}
+
+	stats, err := app.crawlAndPostprocess(ctx)
+
+	record := crawlRecord{
+		SampleTime:  app.sampleTime,
+		PlannedTime: plannedTime,
+		Gap:         gap,
+		MissedTicks: missedTicks,
+		Duration:    time.Since(startTime),
+		crawlStats:  stats,
+	}
+	if err != nil {
+		record.Error = sql.NullString{String: err.Error(), Valid: true}
+	}
+
+	// Record the crawl even if it ran out of time
+	if insertErr := app.ndb.insertCrawl(context.WithoutCancel(ctx), record); insertErr != nil {
+		app.logger.Error("insertCrawl", insertErr)
+	}
+	app.recordParseHealth(context.WithoutCancel(ctx), app.sampleTime, stats.ParseReport)
+
+	return err
+}
+
+// nextCrawl returns the planned time of the crawl after the one planned at
+// plannedTime, which is the next minute mark, and the number of ticks that
+// were skipped because it is now past them.
+func nextCrawl(plannedTime, now int64) (int64, int) {
+	next := (plannedTime/crawlInterval + 1) * crawlInterval
+	if now < next {
+		return next, 0
+	}
+	missedTicks := int((now - next) / crawlInterval)
+	return next + int64(missedTicks)*crawlInterval, missedTicks
+}
+
+// crawlDeadline is the deadline of a crawl starting at startTime: a second
+// before the next minute mark, or the one after if that leaves less than
+// minCrawlTime.
+func crawlDeadline(startTime int64) int64 {
+	deadline := (startTime/crawlInterval+1)*crawlInterval - 1
+	if deadline-startTime < minCrawlTime {
+		deadline += crawlInterval
+	}
+	return deadline
+}
+
+// interpolateGap tells whether upvotes and expected upvotes accrue over the
+// elapsedTime since a story was last seen, in a crawl that took place gap
+// seconds after the previous one. Stories that weren't in the previous crawl
+// are not interpolated.
+func interpolateGap(elapsedTime, gap int) bool {
+	return elapsedTime == gap && gap < maxInterpolatedGap
+}
+
+// gapUpvoteShare is the expected share of a minute's sitewide upvotes a
+// story receives over a gap of elapsedTime seconds between crawls. Its
+// ranks in between are unknown, so it is assumed to spend half of the gap
+// at its ranks before the gap and half at its ranks after.
+func (m attentionModel) gapUpvoteShare(previousRanks, ranks ranksArray, elapsedTime int) float64 {
+	share := (m.expectedUpvoteShareForRanks(previousRanks, elapsedTime, nil) + m.expectedUpvoteShareForRanks(ranks, elapsedTime, nil)) / 2
+	return share * float64(elapsedTime) / 60
+}
+
+// selectRanksAt returns the ranks of every story in the crawl at sampleTime.
+func selectRanksAt(ctx context.Context, tx *sql.Tx, sampleTime int) (map[int]ranksArray, error) {
+	rows, err := tx.QueryContext(ctx, `
+		select id, ifnull(topRank, 0), ifnull(newRank, 0), ifnull(bestRank, 0), ifnull(askRank, 0), ifnull(showRank, 0)
+		from dataset
+		where sampleTime = ?
+	`, sampleTime)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting ranks")
+	}
+	defer rows.Close()
+
+	ranks := make(map[int]ranksArray)
+	for rows.Next() {
+		var id int
+		var r ranksArray
+		if err := rows.Scan(&id, &r[0], &r[1], &r[2], &r[3], &r[4]); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		ranks[id] = r
+	}
+
+	return ranks, rows.Err()
+}
+
+const maxCrawlsPageHours = 7 * 24
+
+// selectCrawls returns the crawls of the last hours, latest first.
+func (ndb newsDatabase) selectCrawls(ctx context.Context, hours int) ([]crawlRecord, error) {
+	rows, err := ndb.db.QueryContext(ctx, `
+		select
+			sampleTime, plannedTime, gap, missedTicks, duration, error, ifnull(errors, 0)
+			, ifnull(sitewideUpvotes, 0), ifnull(sitewideDeltaExpectedUpvotes, 0), ifnull(sitewideExpectedUpvotesShare, 0), ifnull(sitewideComments, 0)
+			, ifnull(stories, 0), ifnull(scraped, 0), ifnull(fromAPI, 0), ifnull(interpolated, 0), ifnull(attentionModel, '')
+			, ifnull(maxItem, 0), ifnull(submissions, 0)
+		from crawls
+		where sampleTime >= (select max(sampleTime) from crawls) - ?
+		order by sampleTime desc
+	`, hours*3600)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting crawls")
+	}
+	defer rows.Close()
+
+	var crawls []crawlRecord
+	for rows.Next() {
+		var c crawlRecord
+		var duration float64
+		err := rows.Scan(&c.SampleTime, &c.PlannedTime, &c.Gap, &c.MissedTicks, &duration, &c.Error, &c.Errors,
+			&c.SitewideUpvotes, &c.SitewideDeltaExpectedUpvotes, &c.SitewideExpectedUpvotesShare, &c.SitewideComments,
+			&c.Stories, &c.Scraped, &c.FromAPI, &c.Interpolated, &c.AttentionModel,
+			&c.MaxItem, &c.Submissions)
+		if err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		c.Duration = time.Duration(duration * float64(time.Second))
+		crawls = append(crawls, c)
+	}
+
+	return crawls, rows.Err()
+}
+
+type CrawlsPageParams struct {
+	Hours int `schema:"hours"`
+}
+
+func (p CrawlsPageParams) hours() int {
+	if p.Hours <= 0 {
+		return 24
+	}
+	return min(p.Hours, maxCrawlsPageHours)
+}
+
+// apiCrawl is the JSON representation of a crawl. The stats of failed
+// crawls are null.
+type apiCrawl struct {
+	SampleTime                   int64    `json:"sampleTime"`
+	PlannedTime                  int64    `json:"plannedTime"`
+	Gap                          int64    `json:"gap"`
+	MissedTicks                  int      `json:"missedTicks"`
+	Duration                     float64  `json:"duration"`
+	Error                        *string  `json:"error"`
+	Errors                       int      `json:"errors"`
+	SitewideUpvotes              *float64 `json:"sitewideUpvotes"`
+	SitewideDeltaExpectedUpvotes *float64 `json:"sitewideDeltaExpectedUpvotes"`
+	SitewideExpectedUpvotesShare *float64 `json:"sitewideExpectedUpvotesShare"`
+	SitewideComments             *float64 `json:"sitewideComments"`
+	Stories                      *int     `json:"stories"`
+	Scraped                      *int     `json:"scraped"`
+	FromAPI                      *int     `json:"fromAPI"`
+	Interpolated                 *int     `json:"interpolated"`
+	AttentionModel               *string  `json:"attentionModel"`
+	MaxItem                      *int     `json:"maxItem"`
+	Submissions                  *int     `json:"submissions"`
+}
+
+func newAPICrawl(c crawlRecord) apiCrawl {
+	a := apiCrawl{
+		SampleTime:  c.SampleTime,
+		PlannedTime: c.PlannedTime,
+		Gap:         c.Gap,
+		MissedTicks: c.MissedTicks,
+		Duration:    c.Duration.Seconds(),
+		Errors:      c.Errors,
+	}
+	if c.Failed() {
+		a.Error = &c.Error.String
+		return a
+	}
+
+	s := c.crawlStats
+	a.SitewideUpvotes = &s.SitewideUpvotes
+	a.SitewideDeltaExpectedUpvotes = &s.SitewideDeltaExpectedUpvotes
+	a.SitewideExpectedUpvotesShare = &s.SitewideExpectedUpvotesShare
+	a.SitewideComments = &s.SitewideComments
+	a.Stories = &s.Stories
+	a.Scraped = &s.Scraped
+	a.FromAPI = &s.FromAPI
+	a.Interpolated = &s.Interpolated
+	a.AttentionModel = &s.AttentionModel
+	a.MaxItem = &s.MaxItem
+	a.Submissions = &s.Submissions
+	return a
+}
+
+// crawlsAPIHandler serves the crawls of the last hours (default 24) as JSON,
+// latest first.
+func (app app) crawlsAPIHandler() func(http.ResponseWriter, *http.Request, CrawlsPageParams) error {
+	return func(w http.ResponseWriter, r *http.Request, p CrawlsPageParams) error {
+		crawls, err := app.ndb.selectCrawls(r.Context(), p.hours())
+		if err != nil {
+			return errors.Wrap(err, "selectCrawls")
+		}
+
+		result := make([]apiCrawl, len(crawls))
+		for i, c := range crawls {
+			result[i] = newAPICrawl(c)
+		}
+
+		b, err := json.Marshal(result)
+		if err != nil {
+			return errors.Wrap(err, "marshaling crawls JSON")
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		_, err = w.Write(b)
+		return errors.Wrap(err, "writing HTTP response")
+	}
+}
+
+// the number of crawls listed in the table on the crawls page. All crawls
+// are shown in the chart.
+const crawlsTableLimit = 60
+
+type CrawlsPageData struct {
+	PageTemplateData
+	Hours  int
+	Crawls []crawlRecord
+}
+
+func (d CrawlsPageData) IsCrawlsPage() bool {
+	return true
+}
+
+func (d CrawlsPageData) LatestCrawls() []crawlRecord {
+	if len(d.Crawls) > crawlsTableLimit {
+		return d.Crawls[:crawlsTableLimit]
+	}
+	return d.Crawls
+}
+
+// CrawlsJSON lists the sample time, sitewide upvotes per minute and
+// sitewide expected upvotes share of each successful crawl, oldest first,
+// for the sitewide upvotes chart.
+func (d CrawlsPageData) CrawlsJSON() template.JS {
+	points := make([][]any, 0, len(d.Crawls))
+	for i := len(d.Crawls) - 1; i >= 0; i-- {
+		c := d.Crawls[i]
+		if c.Failed() {
+			continue
+		}
+		points = append(points, []any{c.SampleTime, c.SitewideUpvotes, c.SitewideExpectedUpvotesShare})
+	}
+	b, _ := json.Marshal(points)
+	return template.JS(b)
+}
+
+func (app app) crawlsHandler() func(http.ResponseWriter, *http.Request, CrawlsPageParams) error {
+	return func(w http.ResponseWriter, r *http.Request, p CrawlsPageParams) error {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		crawls, err := app.ndb.selectCrawls(r.Context(), p.hours())
+		if err != nil {
+			return errors.Wrap(err, "selectCrawls")
+		}
+
+		d := CrawlsPageData{PageTemplateData{UserID: app.getUserID(r)}, p.hours(), crawls}
+
+		err = templates.ExecuteTemplate(w, "crawls.html.tmpl", d)
+		return errors.Wrap(err, "executing crawls page template")
+	}
+}
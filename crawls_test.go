@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+func TestNextCrawl(t *testing.T) {
+	tests := []struct {
+		plannedTime, now int64
+		want             int64
+		wantMissed       int
+	}{
+		// the crawl finished before the next minute mark
+		{plannedTime: 600, now: 630, want: 660},
+		{plannedTime: 600, now: 659, want: 660},
+		// a crawl that started late is still followed by the next minute mark
+		{plannedTime: 610, now: 640, want: 660},
+		// overran into the next minute: the tick is due now
+		{plannedTime: 600, now: 660, want: 660},
+		{plannedTime: 600, now: 700, want: 660},
+		// overran by more than a minute: the ticks in between are skipped
+		{plannedTime: 600, now: 720, want: 720, wantMissed: 1},
+		{plannedTime: 600, now: 905, want: 900, wantMissed: 4},
+	}
+
+	for _, tt := range tests {
+		got, missed := nextCrawl(tt.plannedTime, tt.now)
+		if got != tt.want || missed != tt.wantMissed {
+			t.Errorf("nextCrawl(%d, %d) = %d, %d, want %d, %d", tt.plannedTime, tt.now, got, missed, tt.want, tt.wantMissed)
+		}
+	}
+}
+
+func TestCrawlDeadline(t *testing.T) {
+	tests := []struct {
+		startTime int64
+		want      int64
+	}{
+		{startTime: 600, want: 659},
+		{startTime: 630, want: 659},
+		{startTime: 639, want: 659},
+		// less than minCrawlTime left before the next minute mark
+		{startTime: 640, want: 719},
+		{startTime: 659, want: 719},
+	}
+
+	for _, tt := range tests {
+		if got := crawlDeadline(tt.startTime); got != tt.want {
+			t.Errorf("crawlDeadline(%d) = %d, want %d", tt.startTime, got, tt.want)
+		}
+	}
+}
+
+func TestInterpolateGap(t *testing.T) {
+	tests := []struct {
+		elapsedTime, gap int
+		want             bool
+	}{
+		{elapsedTime: 120, gap: 120, want: true},
+		{elapsedTime: maxInterpolatedGap - 60, gap: maxInterpolatedGap - 60, want: true},
+		// too long to interpolate
+		{elapsedTime: maxInterpolatedGap, gap: maxInterpolatedGap, want: false},
+		// the story wasn't in the previous crawl
+		{elapsedTime: 300, gap: 120, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := interpolateGap(tt.elapsedTime, tt.gap); got != tt.want {
+			t.Errorf("interpolateGap(%d, %d) = %v, want %v", tt.elapsedTime, tt.gap, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"sync"
 
 	stdlib "github.com/multiprocessio/go-sqlite3-stdlib"
 	"github.com/pkg/errors"
@@ -91,6 +92,7 @@ func (ndb newsDatabase) initFrontpageDB(logger *slog.Logger) error {
 			, timestamp int not null
 			, job boolean not null default false
 			, archived boolean not null default false
+			, domain text
 		);
 		`,
 		`
@@ -116,6 +118,9 @@ func (ndb newsDatabase) initFrontpageDB(logger *slog.Logger) error {
 			, rawRank int
 			, upvoteRate float not null default 1
 			, upvoteRateWindow int
+			, attentionModel text
+			, cumulativeComments integer not null default 0
+			, cumulativeExpectedComments real not null default 0
 		);
 		`,
 		`
@@ -131,6 +136,168 @@ func (ndb newsDatabase) initFrontpageDB(logger *slog.Logger) error {
 		ON dataset(id);
 		`,
 		`
+		CREATE TABLE IF NOT EXISTS attention_models(
+			name text primary key
+			, coefficients text not null
+			, fatigueFactor real not null
+			, priorWeight real not null
+			, created integer not null
+		);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS formula_ranks(
+			id integer not null
+			, sampleTime integer not null
+			, formula text not null
+			, rank integer not null
+			, primary key(sampleTime, formula, id)
+		);
+		`,
+		`
+		CREATE INDEX IF NOT EXISTS formula_ranks_id
+		ON formula_ranks(id);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS events(
+			id integer not null
+			, type text not null
+			, startTime integer not null
+			, endTime integer
+			, magnitude integer not null
+			, primary key(id, startTime, type)
+		);
+		`,
+		`
+		CREATE INDEX IF NOT EXISTS events_starttime
+		ON events(startTime);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS resubmissions(
+			id integer not null
+			, originalSubmissionTime integer not null
+			, lowerBound integer not null
+			, upperBound integer not null
+			, confidence real not null
+			, firstSeen integer not null
+			, lastSeen integer not null
+			, primary key(id, firstSeen)
+		);
+		`,
+		`
+		CREATE INDEX IF NOT EXISTS resubmissions_lowerbound
+		ON resubmissions(lowerBound);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS story_revisions(
+			id integer not null
+			, sampleTime integer not null
+			, field text not null
+			, oldValue text not null
+			, newValue text not null
+			, primary key(id, sampleTime, field)
+		);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS story_items(
+			id integer primary key
+			, type text not null
+			, text text not null
+			, kids integer not null
+			, pollOptions text
+			, updated integer not null
+		);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS crawls(
+			sampleTime integer primary key
+			, plannedTime integer not null
+			, gap integer not null
+			, missedTicks integer not null
+			, duration real not null
+			, error text
+			, errors integer
+			, sitewideUpvotes real
+			, sitewideDeltaExpectedUpvotes real
+			, sitewideExpectedUpvotesShare real
+			, sitewideComments real
+			, stories integer
+			, scraped integer
+			, fromAPI integer
+			, interpolated integer
+			, attentionModel text
+			, maxItem integer
+			, submissions integer
+		);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS submissions(
+			id integer primary key
+			, submissionTime integer not null
+			, by text not null
+			, domain text not null
+			, type text not null
+			, dead boolean not null
+			, firstRanked integer
+		);
+		`,
+		`
+		CREATE INDEX IF NOT EXISTS submissions_submissionTime
+		ON submissions(submissionTime);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS discrepancies(
+			sampleTime integer not null
+			, id integer not null
+			, pageType text not null
+			, field text not null
+			, scraped text not null
+			, api text not null
+			, delta real
+			, primary key(sampleTime, id, field)
+		);
+		`,
+		`
+		CREATE INDEX IF NOT EXISTS discrepancies_id
+		ON discrepancies(id);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS parse_health(
+			sampleTime integer not null
+			, pageType text not null
+			, field text not null
+			, attempts integer not null
+			, failures integer not null
+			, primary key(sampleTime, pageType, field)
+		);
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS parse_failures(
+			sampleTime integer not null
+			, pageType text not null
+			, field text not null
+			, storyID text not null
+			, error text not null
+			, html text not null
+		);
+		`,
+		`
+		CREATE INDEX IF NOT EXISTS parse_failures_sampleTime
+		ON parse_failures(sampleTime);
+		`,
+		`
+		CREATE INDEX IF NOT EXISTS parse_failures_field
+		ON parse_failures(pageType, field, sampleTime);
+		`,
+		`
+		CREATE INDEX IF NOT EXISTS parse_health_healthy
+		ON parse_health(pageType, field, sampleTime) WHERE failures = 0;
+		`,
+		`
+		CREATE TABLE IF NOT EXISTS stale_search_indexes(
+			name text primary key
+		);
+		`,
+		`
 		drop view if exists previousCrawl
 		`,
 	}
@@ -147,9 +314,16 @@ func (ndb newsDatabase) initFrontpageDB(logger *slog.Logger) error {
 		`alter table dataset add column upvoteRateWindow int`,
 		`alter table dataset add column upvoteRate float default 0 not null`,
 		`alter table stories add column archived boolean default false not null`,
+		`alter table dataset add column attentionModel text`,
+		`alter table dataset add column cumulativeComments integer not null default 0`,
+		`alter table dataset add column cumulativeExpectedComments real not null default 0`,
+		`alter table stories add column domain text`,
 		`DROP INDEX if exists archived`,
 		`CREATE INDEX IF NOT EXISTS dataset_sampletime on dataset(sampletime)`,
 		`CREATE INDEX IF NOT EXISTS stories_archived on stories(archived) WHERE archived = 1`,
+		`CREATE INDEX IF NOT EXISTS stories_domain on stories(domain)`,
+		`CREATE INDEX IF NOT EXISTS stories_timestamp on stories(timestamp)`,
+		`CREATE INDEX IF NOT EXISTS dataset_sampletime_rawrank on dataset(sampleTime, rawRank) WHERE rawRank IS NOT NULL`,
 
 		// NOTE: Removed UPDATE statement that was running on every startup and blocking for minutes.
 		// This was a one-time migration to backfill upvoteRate for historical data.
@@ -166,7 +340,15 @@ func (ndb newsDatabase) initFrontpageDB(logger *slog.Logger) error {
 	}
 
 	logger.Info("ALTER statements complete")
-	return nil
+
+	// the search index includes the domain, so it is created after the
+	// domain column has been added and filled in
+	if err := ndb.backfillStoryDomains(logger); err != nil {
+		return errors.Wrap(err, "backfillStoryDomains")
+	}
+
+	logger.Info("Creating search indexes", "fts5", fts5Enabled)
+	return errors.Wrap(ndb.initSearchIndexes(), "initSearchIndexes")
 }
 
 func (ndb newsDatabase) initUpvotesDB() error {
@@ -210,6 +392,8 @@ func (ndb newsDatabase) initUpvotesDB() error {
 	return errors.Wrap(err, "attach frontpage database")
 }
 
+var registerExtensions sync.Once
+
 func openNewsDatabase(sqliteDataDir string, logger *slog.Logger) (newsDatabase, error) {
 	logger.Info("Creating data directory if needed")
 	createDataDirIfNotExists(sqliteDataDir)
@@ -222,7 +406,9 @@ func openNewsDatabase(sqliteDataDir string, logger *slog.Logger) (newsDatabase,
 
 	logger.Info("Registering SQLite extensions")
 	// Register some extension functions from go-sqlite3-stdlib so we can actually do math in sqlite3.
-	stdlib.Register("sqlite3_ext")
+	// database/sql panics if a driver is registered twice, e.g. when tests
+	// open several databases.
+	registerExtensions.Do(func() { stdlib.Register("sqlite3_ext") })
 
 	logger.Info("Opening database connection", "file", frontpageDatabaseFilename)
 	// Connect to database with busy timeout to handle concurrent access
@@ -294,10 +480,15 @@ func (ndb newsDatabase) insertDataPoint(tx *sql.Tx, d dataPoint) error {
 			, cumulativeExpectedUpvotes
 			, flagged
 			, dupe
+			, attentionModel
+			, penalty
+			, cumulativeComments
+			, cumulativeExpectedComments
 		) VALUES (
 			?, ?, ?, ?, ?,
 			?, ?, ?, ?, ?,
-			?, ?, ?, ?, ?
+			?, ?, ?, ?, ?,
+			?, ?, ?, ?
 		)
 	`
 
@@ -317,6 +508,10 @@ func (ndb newsDatabase) insertDataPoint(tx *sql.Tx, d dataPoint) error {
 		d.cumulativeExpectedUpvotes,
 		d.flagged,
 		d.dupe,
+		d.attentionModel,
+		d.penalty,
+		d.cumulativeComments,
+		d.cumulativeExpectedComments,
 	)
 	if err != nil {
 		return err
@@ -324,39 +519,126 @@ func (ndb newsDatabase) insertDataPoint(tx *sql.Tx, d dataPoint) error {
 	return nil
 }
 
+// insertOrReplaceStory inserts the story, or updates its title, URL,
+// domain and job flag if they changed. It returns the number of rows
+// changed, and with FTS5, reindexes changed stories in stories_fts.
 func (ndb newsDatabase) insertOrReplaceStory(tx *sql.Tx, story Story) (int64, error) {
+	domain := story.Domain()
+
+	// stories_fts only stores the index, so the old values must be removed
+	// from it before they are overwritten
+	if fts5Enabled {
+		_, err := tx.Exec(`
+			INSERT INTO stories_fts (stories_fts, rowid, title, url, domain, by)
+			SELECT 'delete', id, title, url, domain, by FROM stories
+			WHERE id = ? AND (title, url, job, domain) IS NOT (?, ?, ?, ?)
+		`, story.ID, story.Title, story.URL, story.Job, domain)
+		if err != nil {
+			return 0, errors.Wrap(err, "delete from stories_fts")
+		}
+	}
+
 	sqlStatement := `
-		INSERT INTO stories (id, by, title, url, timestamp, job) VALUES (?, ?, ?, ?, ?, ?) 
-		ON CONFLICT DO UPDATE SET title = excluded.title, url = excluded.url, job = excluded.job
+		INSERT INTO stories (id, by, title, url, timestamp, job, domain) VALUES (?, ?, ?, ?, ?, ?, ?) 
+		ON CONFLICT DO UPDATE SET title = excluded.title, url = excluded.url, job = excluded.job, domain = excluded.domain
+		WHERE (title, url, job, domain) IS NOT (excluded.title, excluded.url, excluded.job, excluded.domain)
 	`
 
-	r, err := tx.Exec(sqlStatement, story.ID, story.By, story.Title, story.URL, story.SubmissionTime, story.Job)
+	r, err := tx.Exec(sqlStatement, story.ID, story.By, story.Title, story.URL, story.SubmissionTime, story.Job, domain)
 	if err != nil {
 		return 0, err
 	}
 
-	return r.RowsAffected()
+	n, err := r.RowsAffected()
+	if err != nil || n == 0 || !fts5Enabled {
+		return n, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO stories_fts (rowid, title, url, domain, by)
+		SELECT id, title, url, domain, by FROM stories WHERE id = ?
+	`, story.ID)
+	return n, errors.Wrap(err, "insert into stories_fts")
+}
+
+// backfillStoryDomains fills in the domain of stories inserted before the
+// domain column was added.
+func (ndb newsDatabase) backfillStoryDomains(logger *slog.Logger) error {
+	rows, err := ndb.db.Query(`SELECT id, url FROM stories WHERE domain IS NULL`)
+	if err != nil {
+		return errors.Wrap(err, "selecting stories without domain")
+	}
+
+	var stories []Story
+	for rows.Next() {
+		var s Story
+		if err := rows.Scan(&s.ID, &s.URL); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "rows.Scan")
+		}
+		stories = append(stories, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "rows.Err")
+	}
+
+	if len(stories) == 0 {
+		return nil
+	}
+
+	logger.Info("Filling in domain of stories", "stories", len(stories))
+
+	tx, err := ndb.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "BeginTX")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, s := range stories {
+		if _, err := tx.Exec(`UPDATE stories SET domain = ? WHERE id = ?`, s.Domain(), s.ID); err != nil {
+			return errors.Wrap(err, "updating story domain")
+		}
+	}
+
+	return errors.Wrap(tx.Commit(), "tx.Commit")
+}
+
+// lastSeenData is the latest datapoint of a story, and its row in the
+// stories table.
+type lastSeenData struct {
+	score                      int
+	cumulativeUpvotes          int
+	cumulativeExpectedUpvotes  float64
+	comments                   int
+	cumulativeComments         int
+	cumulativeExpectedComments float64
+	sampleTime                 int
+	flagged                    bool
+	dupe                       bool
+	title                      string
+	url                        string
+	job                        bool
 }
 
-func (ndb newsDatabase) selectLastSeenData(tx *sql.Tx, id int) (int, int, float64, int, error) {
-	var score int
-	var cumulativeUpvotes int
-	var cumulativeExpectedUpvotes float64
-	var lastSeenTime int
+func (ndb newsDatabase) selectLastSeenData(tx *sql.Tx, id int) (lastSeenData, error) {
+	var d lastSeenData
 
 	sqlStatement := `
-		SELECT score, cumulativeUpvotes, cumulativeExpectedUpvotes, sampleTime
+		SELECT score, cumulativeUpvotes, cumulativeExpectedUpvotes, descendants, cumulativeComments, cumulativeExpectedComments, sampleTime, flagged, dupe, title, url, job
 		FROM dataset
+		JOIN stories USING (id)
 		WHERE id = ?
 		ORDER BY sampleTime DESC LIMIT 1
 	`
 
-	err := tx.QueryRow(sqlStatement, id).Scan(&score, &cumulativeUpvotes, &cumulativeExpectedUpvotes, &lastSeenTime)
-	if err != nil {
-		return score, cumulativeUpvotes, cumulativeExpectedUpvotes, lastSeenTime, err
-	}
+	err := tx.QueryRow(sqlStatement, id).Scan(
+		&d.score, &d.cumulativeUpvotes, &d.cumulativeExpectedUpvotes,
+		&d.comments, &d.cumulativeComments, &d.cumulativeExpectedComments, &d.sampleTime,
+		&d.flagged, &d.dupe, &d.title, &d.url, &d.job,
+	)
 
-	return score, cumulativeUpvotes, cumulativeExpectedUpvotes, lastSeenTime, nil
+	return d, err
 }
 
 func (ndb newsDatabase) selectLastCrawlTime() (int, error) {
@@ -371,6 +653,20 @@ func (ndb newsDatabase) selectLastCrawlTime() (int, error) {
 	return sampleTime, err
 }
 
+// selectCrawlTimeBefore returns the sampleTime of the latest crawl at or
+// before t, or 0 if there is none.
+func (ndb newsDatabase) selectCrawlTimeBefore(t int64) (int64, error) {
+	var sampleTime int64
+
+	sqlStatement := `
+		SELECT ifnull(max(sampleTime),0) from dataset where sampleTime <= ?
+	`
+
+	err := ndb.db.QueryRow(sqlStatement, t).Scan(&sampleTime)
+
+	return sampleTime, err
+}
+
 func (ndb newsDatabase) getMaxScore(ctx context.Context, storyID int) (int, error) {
 	var maxScore int
 	sqlStatement := `SELECT MAX(score) FROM dataset WHERE id = ?`
@@ -469,8 +765,48 @@ func (ndb newsDatabase) purgeStory(ctx context.Context, storyID int) (int64, err
 		}
 	}
 
+	_, err := ndb.db.ExecContext(ctx, `DELETE FROM formula_ranks WHERE id = ?`, storyID)
+	if err != nil {
+		return totalRowsAffected, errors.Wrap(err, "delete from formula_ranks")
+	}
+
+	_, err = ndb.db.ExecContext(ctx, `DELETE FROM events WHERE id = ?`, storyID)
+	if err != nil {
+		return totalRowsAffected, errors.Wrap(err, "delete from events")
+	}
+
+	_, err = ndb.db.ExecContext(ctx, `DELETE FROM resubmissions WHERE id = ?`, storyID)
+	if err != nil {
+		return totalRowsAffected, errors.Wrap(err, "delete from resubmissions")
+	}
+
+	_, err = ndb.db.ExecContext(ctx, `DELETE FROM story_revisions WHERE id = ?`, storyID)
+	if err != nil {
+		return totalRowsAffected, errors.Wrap(err, "delete from story_revisions")
+	}
+
+	_, err = ndb.db.ExecContext(ctx, `DELETE FROM story_items WHERE id = ?`, storyID)
+	if err != nil {
+		return totalRowsAffected, errors.Wrap(err, "delete from story_items")
+	}
+
+	_, err = ndb.db.ExecContext(ctx, `DELETE FROM discrepancies WHERE id = ?`, storyID)
+	if err != nil {
+		return totalRowsAffected, errors.Wrap(err, "delete from discrepancies")
+	}
+
+	if fts5Enabled {
+		_, err = ndb.db.ExecContext(ctx, `
+			INSERT INTO stories_fts (stories_fts, rowid, title, url, domain, by)
+			SELECT 'delete', id, title, url, domain, by FROM stories WHERE id = ?
+		`, storyID)
+		if err != nil {
+			return totalRowsAffected, errors.Wrap(err, "delete from stories_fts")
+		}
+	}
+
 	// Finally, delete the story record
-	_, err := ndb.db.ExecContext(ctx, `DELETE FROM stories WHERE id = ?`, storyID)
+	_, err = ndb.db.ExecContext(ctx, `DELETE FROM stories WHERE id = ?`, storyID)
 	if err != nil {
 		return totalRowsAffected, errors.Wrap(err, "delete from stories")
 	}
@@ -494,6 +830,8 @@ func (ndb newsDatabase) selectStoryDetails(ctx context.Context, id int) (Story,
 		, descendants
 		, cumulativeUpvotes
 		, cumulativeExpectedUpvotes
+		, cumulativeComments
+		, cumulativeExpectedComments
 		, topRank
 		, qnRank
 		, rawRank
@@ -512,6 +850,7 @@ func (ndb newsDatabase) selectStoryDetails(ctx context.Context, id int) (Story,
 	err := ndb.db.QueryRowContext(ctx, sqlStatement, id).Scan(
 		&s.ID, &s.By, &s.Title, &s.URL, &s.SubmissionTime, &s.OriginalSubmissionTime,
 		&s.AgeApprox, &s.Score, &s.Comments, &s.CumulativeUpvotes, &s.CumulativeExpectedUpvotes,
+		&s.CumulativeComments, &s.CumulativeExpectedComments,
 		&s.TopRank, &s.QNRank, &s.RawRank, &s.Flagged, &s.Dupe, &s.Job, &s.Archived,
 	)
 	if err != nil {
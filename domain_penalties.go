@@ -2,20 +2,32 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// DomainPenalty is a row of the domain_penalties table. Rows from the seed
+// data are keyed by host name and have a SampleCount of 0. Rows learned by
+// learnDomainPenalties are keyed by Story.Domain() and record the number of
+// stories the estimate is based on.
 type DomainPenalty struct {
-	Domain     string `gorm:"primaryKey"`
-	AvgPenalty float64
+	Domain      string `gorm:"primaryKey"`
+	AvgPenalty  float64
+	LastUpdated int64 `gorm:"not null;default:0"`
+	SampleCount int   `gorm:"not null;default:0"`
 }
 
 func (ndb newsDatabase) importPenaltiesData(sqliteDataDir string) error {
@@ -56,7 +68,10 @@ func (ndb newsDatabase) importPenaltiesData(sqliteDataDir string) error {
 			return errors.Wrapf(err, "Parsing penalty record %s, %s", record[0], record[1])
 		}
 		err = db.Clauses(clause.OnConflict{ // adding this onConflict clause makes the create into an upsert
-			UpdateAll: true,
+			Columns:   []clause.Column{{Name: "domain"}},
+			DoUpdates: clause.AssignmentColumns([]string{"avg_penalty"}),
+			// don't overwrite learned penalties
+			Where: clause.Where{Exprs: []clause.Expression{clause.Eq{Column: clause.Column{Table: "domain_penalties", Name: "sample_count"}, Value: 0}}},
 		}).Create(&DomainPenalty{Domain: record[0], AvgPenalty: avgPenalty}).Error
 
 		if err != nil {
@@ -67,3 +82,198 @@ func (ndb newsDatabase) importPenaltiesData(sqliteDataDir string) error {
 
 	return nil
 }
+
+// domainPenalties maps domains, as returned by Story.Domain, to the average
+// penalty HN applies to stories from that domain.
+type domainPenalties map[string]float64
+
+// selectDomainPenalties loads the domain_penalties table. The seed data is
+// keyed by host names (e.g. www.theguardian.com), so they are normalized the
+// same way as story URLs. If several hosts normalize to the same domain,
+// their penalties are averaged. Learned penalties take precedence over the
+// seed data.
+func (ndb newsDatabase) selectDomainPenalties(tx *sql.Tx) (domainPenalties, error) {
+	rows, err := tx.Query("select domain, avg_penalty, sample_count from domain_penalties")
+	if err != nil {
+		return nil, errors.Wrap(err, "select from domain_penalties")
+	}
+	defer rows.Close()
+
+	learned := make(domainPenalties)
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for rows.Next() {
+		var host string
+		var avgPenalty float64
+		var sampleCount int
+		if err := rows.Scan(&host, &avgPenalty, &sampleCount); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+
+		if sampleCount > 0 {
+			learned[host] = avgPenalty
+			continue
+		}
+
+		domain := Story{URL: "https://" + host}.Domain()
+		if domain == "" {
+			continue
+		}
+		sums[domain] += avgPenalty
+		counts[domain]++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "select from domain_penalties")
+	}
+
+	penalties := make(domainPenalties, len(sums)+len(learned))
+	for domain, sum := range sums {
+		penalties[domain] = sum / float64(counts[domain])
+	}
+	for domain, penalty := range learned {
+		penalties[domain] = penalty
+	}
+
+	return penalties, nil
+}
+
+// penalty returns the domain penalty for a story, or 0 if there is none.
+// Penalties for twitter.com and github.com apply to all accounts unless
+// there is a penalty for the specific account.
+func (p domainPenalties) penalty(s Story) float64 {
+	domain := s.Domain()
+	if penalty, ok := p[domain]; ok {
+		return penalty
+	}
+	if site, _, ok := strings.Cut(domain, "/"); ok {
+		return p[site]
+	}
+	return 0
+}
+
+const (
+	// Domains with fewer stories on the front page are not learned.
+	minDomainPenaltyStories = 10
+	// Learned penalties are shrunk towards 0 (no penalty), as if there had
+	// been this many additional stories without a penalty.
+	domainPenaltyPriorWeight = 10.0
+	domainPenaltyInterval    = 6 * time.Hour
+	// Penalties are learned from the crawls of this period, so that the
+	// cost of the query doesn't grow with the whole history.
+	domainPenaltyWindow = 30 * 24 * time.Hour
+)
+
+var domainPenaltiesSQL = readSQLSource("domain-penalties.sql")
+
+// learnDomainPenalties estimates the average penalty of each domain from the
+// gap between the raw rank and the actual rank of its stories on the HN front
+// page during the last domainPenaltyWindow, and upserts the estimates into
+// domain_penalties. Each story counts once, no matter how long it was on the
+// front page.
+func (app app) learnDomainPenalties(ctx context.Context) error {
+	t := time.Now()
+
+	rows, err := app.ndb.db.QueryContext(ctx, domainPenaltiesSQL, t.Add(-domainPenaltyWindow).Unix())
+	if err != nil {
+		return errors.Wrap(err, "executing domain-penalties.sql")
+	}
+	defer rows.Close()
+
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for rows.Next() {
+		var id int
+		var url string
+		var penalty float64
+		if err := rows.Scan(&id, &url, &penalty); err != nil {
+			return errors.Wrap(err, "rows.Scan")
+		}
+
+		domain := Story{ID: id, URL: url}.Domain()
+		if domain == "" {
+			continue
+		}
+		sums[domain] += penalty
+		counts[domain]++
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "executing domain-penalties.sql")
+	}
+
+	tx, err := app.ndb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "BeginTx")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt, err := tx.PrepareContext(ctx, `
+		insert into domain_penalties (domain, avg_penalty, last_updated, sample_count) values (?, ?, ?, ?)
+		on conflict (domain) do update set
+			avg_penalty = excluded.avg_penalty
+			, last_updated = excluded.last_updated
+			, sample_count = excluded.sample_count
+	`)
+	if err != nil {
+		return errors.Wrap(err, "preparing domain_penalties upsert")
+	}
+	defer stmt.Close()
+
+	var learned int
+	for domain, n := range counts {
+		if n < minDomainPenaltyStories {
+			continue
+		}
+
+		// A negative average means the domain is boosted, which we don't
+		// model.
+		penalty := math.Max(0, sums[domain]/(float64(n)+domainPenaltyPriorWeight))
+
+		if _, err := stmt.ExecContext(ctx, domain, penalty, t.Unix(), n); err != nil {
+			return errors.Wrapf(err, "upserting domain penalty for %s", domain)
+		}
+		learned++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "tx.Commit")
+	}
+
+	app.logger.Info("Learned domain penalties", "domains", len(counts), "learned", learned, slog.Duration("elapsed", time.Since(t)))
+
+	return nil
+}
+
+// domainPenaltyWorker runs learnDomainPenalties every domainPenaltyInterval.
+// After a restart, it waits until the interval since the last run has passed,
+// so that restarts don't rerun the query.
+func (app app) domainPenaltyWorker(ctx context.Context) {
+	logger := app.logger
+
+	// Recover from panics to prevent worker from dying
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error("Domain penalty worker panic recovered", fmt.Errorf("panic: %v", r))
+		}
+	}()
+
+	var lastUpdated int64
+	err := app.ndb.db.QueryRowContext(ctx, "select ifnull(max(last_updated), 0) from domain_penalties where sample_count > 0").Scan(&lastUpdated)
+	if err != nil {
+		logger.Error("selecting last domain penalty update", err)
+	}
+
+	delay := time.Until(time.Unix(lastUpdated, 0).Add(domainPenaltyInterval))
+	for {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			logger.Info("Domain penalty worker shutting down")
+			return
+		}
+
+		if err := app.learnDomainPenalties(ctx); err != nil {
+			logger.Error("learnDomainPenalties", err)
+		}
+		delay = domainPenaltyInterval
+	}
+}
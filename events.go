@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// An Event is a sustained penalty or boost of a story on the HN front page.
+// An event starts when a story's topRank has diverged from its rawRank by
+// more than eventMinRankDiff ranks for eventMinCrawls consecutive crawls,
+// and ends on the first crawl where it no longer does. As in
+// Story.RankDiff, stories that aren't on the front page have a topRank of
+// 91. Magnitude is the largest rank difference during the event.
+type Event struct {
+	StoryID   int
+	Title     string
+	Type      string
+	StartTime int64
+	EndTime   sql.NullInt64
+	Magnitude int32
+}
+
+const (
+	penaltyEvent = "penalty"
+	boostEvent   = "boost"
+
+	eventMinRankDiff = 10
+	eventMinCrawls   = 5
+)
+
+func (e Event) IsPenalty() bool {
+	return e.Type == penaltyEvent
+}
+
+func (e Event) Ongoing() bool {
+	return !e.EndTime.Valid
+}
+
+func (e Event) StartISOString() string {
+	return time.Unix(e.StartTime, 0).UTC().Format("2006-01-02T15:04")
+}
+
+func (e Event) DurationString() string {
+	endTime := time.Now().Unix()
+	if e.EndTime.Valid {
+		endTime = e.EndTime.Int64
+	}
+	minutes := (endTime - e.StartTime) / 60
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh%02dm", minutes/60, minutes%60)
+}
+
+// eventType returns the type of event a rank difference (rawRank - topRank)
+// belongs to, or "" if the ranks don't diverge enough.
+func eventType(rankDiff int32) string {
+	switch {
+	case rankDiff < -eventMinRankDiff:
+		return penaltyEvent
+	case rankDiff > eventMinRankDiff:
+		return boostEvent
+	default:
+		return ""
+	}
+}
+
+// A rankDiff is the rank difference (rawRank - topRank) of a story in the
+// crawl at sampleTime.
+type rankDiff struct {
+	sampleTime int64
+	diff       int32
+}
+
+// continuesEvent returns true if an ongoing event of type typ continues in
+// the latest crawl, given the rank differences of the story, latest first.
+// The event ends if the story wasn't ranked in the latest crawl.
+func continuesEvent(typ string, diffs []rankDiff, latest int64) bool {
+	return len(diffs) > 0 && diffs[0].sampleTime == latest && eventType(diffs[0].diff) == typ
+}
+
+// detectEvent returns the type and magnitude of the event that starts with
+// the rank differences of a story in the latest eventMinCrawls crawls,
+// latest first, or "" if the story wasn't ranked in each of them or its
+// ranks didn't diverge in the same direction in each of them.
+func detectEvent(diffs []rankDiff, latest int64) (string, int32) {
+	if len(diffs) < eventMinCrawls || diffs[0].sampleTime != latest {
+		return "", 0
+	}
+
+	typ := eventType(diffs[0].diff)
+	var magnitude int32
+	for _, d := range diffs {
+		if eventType(d.diff) != typ {
+			return "", 0
+		}
+		magnitude = max(magnitude, abs(d.diff))
+	}
+	return typ, magnitude
+}
+
+// updateEvents starts and ends penalty and boost events based on the rank
+// differences in the latest crawls. It must run after raw-ranks.sql.
+func (app app) updateEvents(ctx context.Context, tx *sql.Tx) error {
+	t := time.Now()
+
+	sampleTimes := make([]int64, 0, eventMinCrawls)
+	{
+		rows, err := tx.QueryContext(ctx, "select distinct sampleTime from dataset order by sampleTime desc limit ?", eventMinCrawls)
+		if err != nil {
+			return errors.Wrap(err, "selecting latest sampleTimes")
+		}
+		for rows.Next() {
+			var sampleTime int64
+			if err := rows.Scan(&sampleTime); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "rows.Scan")
+			}
+			sampleTimes = append(sampleTimes, sampleTime)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "selecting latest sampleTimes")
+		}
+	}
+	if len(sampleTimes) == 0 {
+		return nil
+	}
+	latest := sampleTimes[0]
+	oldest := sampleTimes[len(sampleTimes)-1]
+
+	// the rank differences of each story in the latest crawls, latest first
+	rankDiffs := make(map[int][]rankDiff)
+	{
+		rows, err := tx.QueryContext(ctx, `
+			select id, sampleTime, rawRank - ifnull(topRank, 91)
+			from dataset
+			where sampleTime >= ?
+			and rawRank is not null
+			order by sampleTime desc
+		`, oldest)
+		if err != nil {
+			return errors.Wrap(err, "selecting rank differences")
+		}
+		for rows.Next() {
+			var id int
+			var d rankDiff
+			if err := rows.Scan(&id, &d.sampleTime, &d.diff); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "rows.Scan")
+			}
+			rankDiffs[id] = append(rankDiffs[id], d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "selecting rank differences")
+		}
+	}
+
+	ongoing := make(map[int]Event)
+	{
+		rows, err := tx.QueryContext(ctx, "select id, type, startTime, magnitude from events where endTime is null")
+		if err != nil {
+			return errors.Wrap(err, "selecting ongoing events")
+		}
+		for rows.Next() {
+			var e Event
+			if err := rows.Scan(&e.StoryID, &e.Type, &e.StartTime, &e.Magnitude); err != nil {
+				rows.Close()
+				return errors.Wrap(err, "rows.Scan")
+			}
+			ongoing[e.StoryID] = e
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return errors.Wrap(err, "selecting ongoing events")
+		}
+	}
+
+	var started, ended int
+
+	for id, e := range ongoing {
+		diffs := rankDiffs[id]
+
+		if continuesEvent(e.Type, diffs, latest) {
+			if abs(diffs[0].diff) > e.Magnitude {
+				_, err := tx.ExecContext(ctx, "update events set magnitude = ? where id = ? and type = ? and startTime = ?", abs(diffs[0].diff), id, e.Type, e.StartTime)
+				if err != nil {
+					return errors.Wrap(err, "updating event")
+				}
+			}
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, "update events set endTime = ? where id = ? and type = ? and startTime = ?", latest, id, e.Type, e.StartTime)
+		if err != nil {
+			return errors.Wrap(err, "ending event")
+		}
+		delete(ongoing, id)
+		ended++
+	}
+
+	for id, diffs := range rankDiffs {
+		if _, ok := ongoing[id]; ok {
+			continue
+		}
+		typ, magnitude := detectEvent(diffs, latest)
+		if typ == "" {
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, "insert into events (id, type, startTime, magnitude) values (?, ?, ?, ?)", id, typ, oldest, magnitude)
+		if err != nil {
+			return errors.Wrap(err, "inserting event")
+		}
+		started++
+	}
+
+	app.logger.Info("Finished executing updateEvents", "started", started, "ended", ended, slog.Duration("elapsed", time.Since(t)))
+
+	return nil
+}
+
+const eventsPageLimit = 200
+
+// selectEvents returns the latest events of a story, or of all stories if
+// storyID is 0.
+func (ndb newsDatabase) selectEvents(ctx context.Context, storyID int) ([]Event, error) {
+	query := `
+		select id, title, type, startTime, endTime, magnitude
+		from events join stories using (id)
+		%s
+		order by startTime desc
+		limit %d
+	`
+	var rows *sql.Rows
+	var err error
+	if storyID == 0 {
+		rows, err = ndb.db.QueryContext(ctx, fmt.Sprintf(query, "", eventsPageLimit))
+	} else {
+		rows, err = ndb.db.QueryContext(ctx, fmt.Sprintf(query, "where id = ?", eventsPageLimit), storyID)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting events")
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.StoryID, &e.Title, &e.Type, &e.StartTime, &e.EndTime, &e.Magnitude); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+type EventsPageData struct {
+	PageTemplateData
+	Events []Event
+}
+
+func (d EventsPageData) IsEventsPage() bool {
+	return true
+}
+
+func (app app) eventsHandler() func(http.ResponseWriter, *http.Request, struct{}) error {
+	return func(w http.ResponseWriter, r *http.Request, p struct{}) error {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		events, err := app.ndb.selectEvents(r.Context(), 0)
+		if err != nil {
+			return errors.Wrap(err, "selectEvents")
+		}
+
+		d := EventsPageData{PageTemplateData{UserID: app.getUserID(r)}, events}
+
+		err = templates.ExecuteTemplate(w, "events.html.tmpl", d)
+		return errors.Wrap(err, "executing events page template")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import "testing"
+
+func TestEventType(t *testing.T) {
+	tests := []struct {
+		rankDiff int32
+		want     string
+	}{
+		{0, ""},
+		{eventMinRankDiff, ""},
+		{-eventMinRankDiff, ""},
+		{eventMinRankDiff + 1, boostEvent},
+		{-eventMinRankDiff - 1, penaltyEvent},
+		// not on the front page (topRank 91)
+		{20 - 91, penaltyEvent},
+	}
+
+	for _, tt := range tests {
+		if got := eventType(tt.rankDiff); got != tt.want {
+			t.Errorf("eventType(%d) = %q, want %q", tt.rankDiff, got, tt.want)
+		}
+	}
+}
+
+// crawlDiffs returns rank differences in consecutive crawls a minute apart,
+// latest first, with the first one at latest.
+func crawlDiffs(latest int64, diffs ...int32) []rankDiff {
+	result := make([]rankDiff, len(diffs))
+	for i, d := range diffs {
+		result[i] = rankDiff{latest - int64(i)*60, d}
+	}
+	return result
+}
+
+func TestDetectEvent(t *testing.T) {
+	const latest = 1_700_000_000
+
+	tests := []struct {
+		name          string
+		diffs         []rankDiff
+		wantType      string
+		wantMagnitude int32
+	}{
+		{
+			name:          "penalty",
+			diffs:         crawlDiffs(latest, -15, -20, -12, -30, -11),
+			wantType:      penaltyEvent,
+			wantMagnitude: 30,
+		},
+		{
+			name:          "boost",
+			diffs:         crawlDiffs(latest, 12, 12, 15, 11, 11),
+			wantType:      boostEvent,
+			wantMagnitude: 15,
+		},
+		{
+			name:  "too few crawls",
+			diffs: crawlDiffs(latest, -15, -20, -12, -30),
+		},
+		{
+			name:  "diverged in only some crawls",
+			diffs: crawlDiffs(latest, -15, -20, -5, -30, -11),
+		},
+		{
+			name:  "diverged in both directions",
+			diffs: crawlDiffs(latest, -15, -20, 15, -30, -11),
+		},
+		{
+			name:  "not ranked in the latest crawl",
+			diffs: crawlDiffs(latest-60, -15, -20, -12, -30, -11),
+		},
+		{
+			name:  "no divergence",
+			diffs: crawlDiffs(latest, 0, 1, -2, 0, 3),
+		},
+	}
+
+	for _, tt := range tests {
+		typ, magnitude := detectEvent(tt.diffs, latest)
+		if typ != tt.wantType {
+			t.Errorf("%s: detectEvent returned type %q, want %q", tt.name, typ, tt.wantType)
+			continue
+		}
+		if tt.wantType != "" && magnitude != tt.wantMagnitude {
+			t.Errorf("%s: detectEvent returned magnitude %d, want %d", tt.name, magnitude, tt.wantMagnitude)
+		}
+	}
+}
+
+func TestContinuesEvent(t *testing.T) {
+	const latest = 1_700_000_000
+
+	tests := []struct {
+		name  string
+		typ   string
+		diffs []rankDiff
+		want  bool
+	}{
+		{name: "still penalized", typ: penaltyEvent, diffs: crawlDiffs(latest, -15, -20), want: true},
+		{name: "penalty lifted", typ: penaltyEvent, diffs: crawlDiffs(latest, -3, -20), want: false},
+		{name: "penalty turned into boost", typ: penaltyEvent, diffs: crawlDiffs(latest, 15, -20), want: false},
+		{name: "still boosted", typ: boostEvent, diffs: crawlDiffs(latest, 15), want: true},
+		{name: "not ranked in the latest crawl", typ: penaltyEvent, diffs: crawlDiffs(latest-60, -15, -20), want: false},
+		{name: "no longer ranked", typ: penaltyEvent, diffs: nil, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := continuesEvent(tt.typ, tt.diffs, latest); got != tt.want {
+			t.Errorf("%s: continuesEvent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Feeds for the front page rankings. The content of each feed only changes
+// when there is a new crawl, so the latest sampleTime is used for
+// Last-Modified and ETag headers.
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	ID        string     `xml:"id"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Links     []atomLink `xml:"link"`
+	Author    atomAuthor `xml:"author"`
+	Summary   string     `xml:"summary"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Comments    string  `xml:"comments"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+// storyGUID is the stable identifier of a story in all feeds.
+func storyGUID(id int) string {
+	return fmt.Sprintf("https://news.ycombinator.com/item?id=%d", id)
+}
+
+func storyFeedSummary(s Story) string {
+	var summary strings.Builder
+
+	fmt.Fprintf(&summary, "×%s upvoteRate", s.UpvoteRateString())
+
+	if d := s.RankDiff(); d != 0 {
+		fmt.Fprintf(&summary, ", rank delta %+d", d)
+	}
+
+	fmt.Fprintf(&summary, ", %d points by %s, %d comments", s.Score, s.By, s.Comments)
+
+	return summary.String()
+}
+
+// siteURL returns the URL of the root of this site as seen by the client.
+func siteURL(r *http.Request) string {
+	scheme := "https"
+	if strings.HasPrefix(r.Host, "localhost") || strings.HasPrefix(r.Host, "127.0.0.1") {
+		scheme = "http"
+	}
+	return scheme + "://" + r.Host
+}
+
+// rankingPath returns the path of the front page for a ranking. The hntop
+// ranking is served at the root of the site.
+func rankingPath(ranking string) string {
+	if ranking == "hntop" {
+		return "/"
+	}
+	return "/" + ranking
+}
+
+// notModified reports whether the client already has the version of a feed
+// with the given ETag and modification time, following the precedence of
+// http.ServeContent: If-None-Match is used if present, If-Modified-Since
+// otherwise.
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+			if t == "*" || t == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates have a resolution of one second
+	return !modtime.Truncate(time.Second).After(ims)
+}
+
+func newAtomFeed(d frontPageData, sampleTime int64, baseURL string, pageURL string) atomFeed {
+	updated := time.Unix(sampleTime, 0).UTC().Format(time.RFC3339)
+
+	entries := make([]atomEntry, len(d.Stories))
+	for i, s := range d.Stories {
+		published := time.Unix(s.SubmissionTime, 0).UTC().Format(time.RFC3339)
+		entries[i] = atomEntry{
+			Title:     s.Title,
+			ID:        storyGUID(s.ID),
+			Published: published,
+			Updated:   published,
+			Links: []atomLink{
+				{Href: s.URL},
+				{Href: fmt.Sprintf("%s/stats?id=%d", baseURL, s.ID), Rel: "related"},
+			},
+			Author:  atomAuthor{Name: s.By},
+			Summary: storyFeedSummary(s.Story),
+		}
+	}
+
+	return atomFeed{
+		Title:   "Quality News: " + d.Ranking,
+		ID:      pageURL,
+		Updated: updated,
+		Links:   []atomLink{{Href: pageURL}},
+		Entries: entries,
+	}
+}
+
+func newRSSFeed(d frontPageData, sampleTime int64, baseURL string, pageURL string) rssFeed {
+	items := make([]rssItem, len(d.Stories))
+	for i, s := range d.Stories {
+		items[i] = rssItem{
+			Title:       s.Title,
+			Link:        s.URL,
+			GUID:        rssGUID{Value: storyGUID(s.ID), IsPermaLink: true},
+			PubDate:     time.Unix(s.SubmissionTime, 0).UTC().Format(time.RFC1123Z),
+			Comments:    storyGUID(s.ID),
+			Description: storyFeedSummary(s.Story),
+		}
+	}
+
+	return rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:         "Quality News: " + d.Ranking,
+			Link:          pageURL,
+			Description:   "Hacker News stories ranked by the Quality News " + d.Ranking + " ranking",
+			LastBuildDate: time.Unix(sampleTime, 0).UTC().Format(time.RFC1123Z),
+			Items:         items,
+		},
+	}
+}
+
+// feedHandler serves the stories for a ranking as an Atom or RSS feed.
+// format must be "atom" or "rss".
+func (app app) feedHandler(ranking string, format string) func(http.ResponseWriter, *http.Request, OptionalFrontPageParams) error {
+	return func(w http.ResponseWriter, r *http.Request, params OptionalFrontPageParams) error {
+		p := params.WithDefaults()
+
+		sampleTime, err := app.frontPageSampleTime(p)
+		if err != nil {
+			return errors.Wrap(err, "frontPageSampleTime")
+		}
+
+		// The feed only changes after the next crawl, which happens about one
+		// minute after sampleTime.
+		maxAge := min(max(60-(time.Now().Unix()-sampleTime), 0), 60)
+		etag := fmt.Sprintf(`"%s-%s-%d-%x"`, ranking, format, sampleTime, hashString(r.URL.RawQuery))
+		modtime := time.Unix(sampleTime, 0)
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+		w.Header().Set("ETag", etag)
+
+		// Answer conditional requests before generating the page, so that
+		// readers polling within a crawl cycle don't cost a ranking query.
+		if notModified(r, etag, modtime) {
+			w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+
+		d, err := app.getFrontPageData(r.Context(), ranking, p, sampleTime, sql.NullInt64{})
+		if err != nil {
+			return errors.Wrap(err, "getFrontPageData")
+		}
+
+		baseURL := siteURL(r)
+		pageURL := baseURL + rankingPath(ranking)
+		if r.URL.RawQuery != "" {
+			pageURL += "?" + r.URL.RawQuery
+		}
+
+		var feed any
+		if format == "atom" {
+			w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+			feed = newAtomFeed(d, sampleTime, baseURL, pageURL)
+		} else {
+			w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+			feed = newRSSFeed(d, sampleTime, baseURL, pageURL)
+		}
+
+		b, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			return errors.Wrap(err, "marshaling feed")
+		}
+
+		http.ServeContent(w, r, "", modtime, bytes.NewReader(append([]byte(xml.Header), b...)))
+
+		return nil
+	}
+}
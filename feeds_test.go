@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRankingPath(t *testing.T) {
+	tests := []struct {
+		ranking string
+		want    string
+	}{
+		{"hntop", "/"},
+		{"new", "/new"},
+		{"best-upvoterate", "/best-upvoterate"},
+	}
+
+	for _, tt := range tests {
+		if got := rankingPath(tt.ranking); got != tt.want {
+			t.Errorf("rankingPath(%q) = %q, want %q", tt.ranking, got, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	etag := `"hntop-atom-1700000000-0"`
+	modtime := time.Unix(1700000000, 0)
+	date := func(t time.Time) string { return t.UTC().Format(http.TimeFormat) }
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "unconditional", want: false},
+		{name: "matching etag", headers: map[string]string{"If-None-Match": etag}, want: true},
+		{name: "weak etag in list", headers: map[string]string{"If-None-Match": `"other", W/` + etag}, want: true},
+		{name: "any etag", headers: map[string]string{"If-None-Match": "*"}, want: true},
+		{name: "older etag", headers: map[string]string{"If-None-Match": `"hntop-atom-1699999940-0"`}, want: false},
+		{name: "same modification time", headers: map[string]string{"If-Modified-Since": date(modtime)}, want: true},
+		{name: "modified since", headers: map[string]string{"If-Modified-Since": date(modtime.Add(-time.Minute))}, want: false},
+		{name: "invalid date", headers: map[string]string{"If-Modified-Since": "yesterday"}, want: false},
+		{
+			// If-None-Match takes precedence over If-Modified-Since
+			name:    "older etag with same modification time",
+			headers: map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": date(modtime)},
+			want:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		r, err := http.NewRequest("GET", "/feeds/hntop.atom", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range tt.headers {
+			r.Header.Set(k, v)
+		}
+		if got := notModified(r, etag, modtime); got != tt.want {
+			t.Errorf("%s: notModified = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+	"gonum.org/v1/gonum/mat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+// The fit command estimates the coefficients of the attention model from
+// the local dataset, as bayesian-quality-pagetype-rank.R does from the
+// hacker-news-data repository.
+//
+// For each page type, the upvotes a story receives between two crawls are
+// modeled as Poisson distributed, with a log link:
+//
+//	log E[upvotes] = log(sitewideUpvotes * elapsedTime/60)
+//	                 + pageTypeCoefficient
+//	                 + pageCoefficient * log(page)
+//	                 + rankCoefficient * log(rankOnPage) / page
+//
+// which is the model in expectedUpvoteShare, with sitewide upvotes as an
+// offset. Because a story's upvotes can't be attributed to one of several
+// page types, only datapoints where the story is ranked on exactly one page
+// type are used. Unlike the R script, the model has no per-story quality
+// term, so stories are assumed to be of average quality.
+
+// an observation of the upvotes of a single story between two crawls
+type glmObservation struct {
+	x      [3]float64
+	y      float64
+	offset float64
+}
+
+type poissonGLMFit struct {
+	coefficients      []float64
+	standardErrors    []float64
+	deviance          float64
+	nullDeviance      float64
+	pearsonChiSquared float64
+	nObservations     int
+	nParameters       int
+	iterations        int
+}
+
+// dispersion is the Pearson estimate of the dispersion parameter. It is 1
+// if the data is really Poisson distributed.
+func (f poissonGLMFit) dispersion() float64 {
+	return f.pearsonChiSquared / float64(f.nObservations-f.nParameters)
+}
+
+const (
+	glmMaxIterations = 50
+	glmTolerance     = 1e-8
+)
+
+// fitPoissonGLM fits a Poisson GLM with log link by iteratively reweighted
+// least squares. Columns of x that are zero for every observation can't be
+// estimated: their coefficients are 0 and their standard errors NaN.
+func fitPoissonGLM(observations []glmObservation) (poissonGLMFit, error) {
+	nColumns := len(observations[0].x)
+
+	active := make([]int, 0, nColumns)
+	for j := 0; j < nColumns; j++ {
+		for _, o := range observations {
+			if o.x[j] != 0 {
+				active = append(active, j)
+				break
+			}
+		}
+	}
+	p := len(active)
+
+	fit := poissonGLMFit{
+		coefficients:   make([]float64, nColumns),
+		standardErrors: make([]float64, nColumns),
+		nObservations:  len(observations),
+		nParameters:    p,
+	}
+	if fit.nObservations <= p {
+		return fit, fmt.Errorf("not enough observations (%d) to fit %d parameters", fit.nObservations, p)
+	}
+
+	// Start with a model where the expected upvotes are proportional to the
+	// offset. The first column is the intercept.
+	var sumY, sumExpOffset float64
+	for _, o := range observations {
+		sumY += o.y
+		sumExpOffset += math.Exp(o.offset)
+	}
+	if sumY == 0 {
+		return fit, errors.New("no upvotes in the data")
+	}
+	beta := mat.NewVecDense(p, nil)
+	beta.SetVec(0, math.Log(sumY/sumExpOffset))
+
+	eta := func(o glmObservation) float64 {
+		e := o.offset
+		for k, j := range active {
+			e += o.x[j] * beta.AtVec(k)
+		}
+		return e
+	}
+
+	xtwx := mat.NewSymDense(p, nil)
+	xtwz := mat.NewVecDense(p, nil)
+	var chol mat.Cholesky
+
+	deviance := math.Inf(1)
+	for fit.iterations = 1; fit.iterations <= glmMaxIterations; fit.iterations++ {
+		xtwx.Zero()
+		xtwz.Zero()
+
+		for _, o := range observations {
+			e := eta(o)
+			mu := math.Exp(e)
+			// working response, with weight mu
+			z := e - o.offset + (o.y-mu)/mu
+
+			for k, j := range active {
+				xtwz.SetVec(k, xtwz.AtVec(k)+mu*o.x[j]*z)
+				for l := k; l < p; l++ {
+					xtwx.SetSym(k, l, xtwx.At(k, l)+mu*o.x[j]*o.x[active[l]])
+				}
+			}
+		}
+
+		if ok := chol.Factorize(xtwx); !ok {
+			return fit, errors.New("singular information matrix")
+		}
+		if err := chol.SolveVecTo(beta, xtwz); err != nil {
+			return fit, errors.Wrap(err, "solving IRLS step")
+		}
+
+		newDeviance := 0.0
+		for _, o := range observations {
+			newDeviance += poissonDeviance(o.y, math.Exp(eta(o)))
+		}
+
+		converged := math.Abs(newDeviance-deviance) < glmTolerance*(math.Abs(newDeviance)+0.1)
+		deviance = newDeviance
+		if converged {
+			break
+		}
+	}
+	if fit.iterations > glmMaxIterations {
+		return fit, fmt.Errorf("IRLS did not converge after %d iterations", glmMaxIterations)
+	}
+
+	// The covariance of the estimates is the inverse of the Fisher
+	// information at the solution.
+	var covariance mat.SymDense
+	if err := chol.InverseTo(&covariance); err != nil {
+		return fit, errors.Wrap(err, "inverting information matrix")
+	}
+
+	for j := range fit.standardErrors {
+		fit.standardErrors[j] = math.NaN()
+	}
+	for k, j := range active {
+		fit.coefficients[j] = beta.AtVec(k)
+		fit.standardErrors[j] = math.Sqrt(covariance.At(k, k))
+	}
+
+	fit.deviance = deviance
+	for _, o := range observations {
+		mu := math.Exp(eta(o))
+		fit.pearsonChiSquared += (o.y - mu) * (o.y - mu) / mu
+		// The null model has only the intercept (and the offset)
+		fit.nullDeviance += poissonDeviance(o.y, math.Exp(o.offset)*sumY/sumExpOffset)
+	}
+
+	return fit, nil
+}
+
+func poissonDeviance(y, mu float64) float64 {
+	if y == 0 {
+		return 2 * mu
+	}
+	return 2 * (y*math.Log(y/mu) - (y - mu))
+}
+
+// selectFitObservations returns, for each page type, the upvotes of
+// stories between consecutive crawls that were ranked on only that page
+// type.
+func (ndb newsDatabase) selectFitObservations(ctx context.Context, since int64) ([nPageTypes][]glmObservation, error) {
+	var observations [nPageTypes][]glmObservation
+
+	rows, err := ndb.db.QueryContext(ctx, `
+		with deltas as (
+			select
+				sampleTime
+				, ifnull(topRank, 0) as topRank
+				, ifnull(newRank, 0) as newRank
+				, ifnull(bestRank, 0) as bestRank
+				, ifnull(askRank, 0) as askRank
+				, ifnull(showRank, 0) as showRank
+				, min(
+					ifnull(topRank, 1e9), ifnull(newRank, 1e9), ifnull(bestRank, 1e9),
+					ifnull(askRank, 1e9), ifnull(showRank, 1e9)
+				) as minRank
+				, score - lag(score) over w as upvotes
+				, sampleTime - lag(sampleTime) over w as elapsedTime
+			from dataset
+			where sampleTime >= ?
+			window w as (partition by id order by sampleTime)
+		)
+		, sitewide as (
+			select sampleTime, sum(upvotes * 60.0 / elapsedTime) as sitewideUpvotes
+			from deltas
+			where elapsedTime < ?
+			-- as in the crawler, only stories within the default crawl depth
+			-- count towards sitewide upvotes
+			and minRank <= ?
+			group by sampleTime
+		)
+		select topRank, newRank, bestRank, askRank, showRank, upvotes, elapsedTime, sitewideUpvotes
+		from deltas join sitewide using (sampleTime)
+		where elapsedTime < ?
+		and upvotes >= 0
+		and sitewideUpvotes > 0
+	`, since, maxElapsedTime, defaultCrawlDepth, maxElapsedTime)
+	if err != nil {
+		return observations, errors.Wrap(err, "selecting observations")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ranks ranksArray
+		var upvotes, elapsedTime int
+		var sitewideUpvotes float64
+
+		if err := rows.Scan(&ranks[0], &ranks[1], &ranks[2], &ranks[3], &ranks[4], &upvotes, &elapsedTime, &sitewideUpvotes); err != nil {
+			return observations, errors.Wrap(err, "rows.Scan")
+		}
+
+		pageType := -1
+		for pt, rank := range ranks {
+			if rank == 0 {
+				continue
+			}
+			if pageType != -1 {
+				// ranked on more than one page type
+				pageType = -1
+				break
+			}
+			pageType = pt
+		}
+		if pageType == -1 {
+			continue
+		}
+
+		rank := ranks[pageType]
+		page := float64((rank-1)/30 + 1)
+		rankOnPage := float64((rank-1)%30 + 1)
+
+		observations[pageType] = append(observations[pageType], glmObservation{
+			x:      [3]float64{1, math.Log(page), math.Log(rankOnPage) / page},
+			y:      float64(upvotes),
+			offset: math.Log(sitewideUpvotes * float64(elapsedTime) / 60),
+		})
+	}
+
+	return observations, rows.Err()
+}
+
+func runFitCommand(ctx context.Context, logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("fit", flag.ExitOnError)
+	dataDir := flags.String("data-dir", os.Getenv("SQLITE_DATA_DIR"), "directory containing the database")
+	since := flags.Duration("since", 0, "only use data from this long ago until now (default: all data)")
+	name := flags.String("name", "fitted-"+time.Now().UTC().Format("2006-01-02"), "name of the fitted attention model")
+	out := flags.String("out", "attention-model.json", "file to write the fitted model to, in the format of ATTENTION_MODEL_FILE")
+	_ = flags.Parse(args)
+
+	if *dataDir == "" {
+		flags.Usage()
+		return errors.New("-data-dir or SQLITE_DATA_DIR is required")
+	}
+
+	ndb, err := openNewsDatabase(*dataDir, logger)
+	if err != nil {
+		return errors.Wrap(err, "openNewsDatabase")
+	}
+	defer ndb.close()
+
+	var sinceTime int64
+	if *since != 0 {
+		sinceTime = time.Now().Add(-*since).Unix()
+	}
+
+	observations, err := ndb.selectFitObservations(ctx, sinceTime)
+	if err != nil {
+		return errors.Wrap(err, "selectFitObservations")
+	}
+
+	// The fatigue factor and prior weight are not estimated here.
+	model := defaultAttentionModel
+	model.Name = *name
+
+	z := distuv.UnitNormal.Quantile(0.975)
+
+	report := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(report, "pageType\tcoefficient\testimate\t95% CI\t\t")
+
+	var goodnessOfFit []string
+
+	for pageType := top; pageType <= show; pageType++ {
+		pageTypeName := pageTypes[pageType]
+
+		if len(observations[pageType]) == 0 {
+			logger.Warn("No observations for page type. Keeping current coefficients", "pageType", pageTypeName)
+			continue
+		}
+
+		fit, err := fitPoissonGLM(observations[pageType])
+		if err != nil {
+			return errors.Wrapf(err, "fitting %s coefficients", pageTypeName)
+		}
+
+		// Upvote data is usually overdispersed, which makes the Poisson
+		// standard errors too small. Scale them as in a quasi-Poisson model.
+		scale := math.Sqrt(math.Max(1, fit.dispersion()))
+
+		model.Coefficients[pageType] = pageCoefficients{fit.coefficients[0], fit.coefficients[1], fit.coefficients[2]}
+
+		for j, coefficientName := range []string{"pageType", "page", "rank"} {
+			se := fit.standardErrors[j] * scale
+			fmt.Fprintf(report, "%s\t%s\t%.6f\t%.6f\t%.6f\t\n", pageTypeName, coefficientName, fit.coefficients[j], fit.coefficients[j]-z*se, fit.coefficients[j]+z*se)
+		}
+
+		goodnessOfFit = append(goodnessOfFit, fmt.Sprintf(
+			"%s\t%d\t%d\t%.1f\t%.1f\t%.3f\t%.2f\t",
+			pageTypeName, fit.nObservations, fit.iterations, fit.deviance, fit.nullDeviance, 1-fit.deviance/fit.nullDeviance, fit.dispersion()))
+	}
+
+	fmt.Fprintln(report)
+	fmt.Fprintln(report, "pageType\tobservations\titerations\tdeviance\tnull deviance\tpseudo R²\tdispersion\t")
+	for _, line := range goodnessOfFit {
+		fmt.Fprintln(report, line)
+	}
+	if err := report.Flush(); err != nil {
+		return errors.Wrap(err, "writing report")
+	}
+
+	b, err := json.MarshalIndent([]attentionModel{model}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal")
+	}
+	if err := os.WriteFile(*out, append(b, '\n'), 0o644); err != nil {
+		return errors.Wrapf(err, "writing %s", *out)
+	}
+
+	logger.Info("Wrote fitted attention model", "name", model.Name, "file", *out)
+
+	return nil
+}
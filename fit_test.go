@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// glmObservations returns observations of the attention model's columns
+// (intercept, log(page), log(rankOnPage)/page) whose upvotes are exactly
+// the expected upvotes for coefficients, so that the fit recovers them.
+func glmObservations(coefficients [3]float64, pages int) []glmObservation {
+	var observations []glmObservation
+	for page := 1; page <= pages; page++ {
+		for rankOnPage := 1; rankOnPage <= 30; rankOnPage++ {
+			for _, sitewideUpvotes := range []float64{5, 20} {
+				o := glmObservation{
+					x:      [3]float64{1, math.Log(float64(page)), math.Log(float64(rankOnPage)) / float64(page)},
+					offset: math.Log(sitewideUpvotes),
+				}
+				e := o.offset
+				for j, c := range coefficients {
+					e += o.x[j] * c
+				}
+				o.y = math.Exp(e)
+				observations = append(observations, o)
+			}
+		}
+	}
+	return observations
+}
+
+func TestFitPoissonGLM(t *testing.T) {
+	tests := []struct {
+		name         string
+		coefficients [3]float64
+		pages        int
+		// columns that are zero for every observation
+		inactive []int
+	}{
+		{name: "builtin coefficients", coefficients: [3]float64{-2.886, -0.7, -0.6}, pages: 3},
+		{name: "flat", coefficients: [3]float64{-4, 0, 0}, pages: 3},
+		{name: "deep", coefficients: [3]float64{-3.5, -1.2, -0.4}, pages: 10},
+		// log(page) is zero on the first page
+		{name: "first page only", coefficients: [3]float64{-2, 0, -0.8}, pages: 1, inactive: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fit, err := fitPoissonGLM(glmObservations(tt.coefficients, tt.pages))
+			if err != nil {
+				t.Fatalf("fitPoissonGLM returned error: %v", err)
+			}
+
+			for j, want := range tt.coefficients {
+				if got := fit.coefficients[j]; math.Abs(got-want) > 1e-6 {
+					t.Errorf("coefficient %d = %f, want %f", j, got, want)
+				}
+			}
+
+			if fit.nParameters != 3-len(tt.inactive) {
+				t.Errorf("nParameters = %d, want %d", fit.nParameters, 3-len(tt.inactive))
+			}
+			for _, j := range tt.inactive {
+				if !math.IsNaN(fit.standardErrors[j]) {
+					t.Errorf("standard error of inactive column %d = %f, want NaN", j, fit.standardErrors[j])
+				}
+			}
+
+			if fit.deviance > 1e-6 {
+				t.Errorf("deviance = %f, want 0 for a perfect fit", fit.deviance)
+			}
+			if fit.nullDeviance < fit.deviance {
+				t.Errorf("nullDeviance = %f, want at least the deviance %f", fit.nullDeviance, fit.deviance)
+			}
+		})
+	}
+}
+
+func TestFitPoissonGLMErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		observations []glmObservation
+	}{
+		{
+			name: "too few observations",
+			observations: []glmObservation{
+				{x: [3]float64{1, 0, 0}, y: 2, offset: 1},
+				{x: [3]float64{1, 0, 1}, y: 1, offset: 1},
+			},
+		},
+		{
+			name: "no upvotes",
+			observations: []glmObservation{
+				{x: [3]float64{1, 0, 0}, offset: 1},
+				{x: [3]float64{1, 0, 1}, offset: 1},
+				{x: [3]float64{1, 0, 2}, offset: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if _, err := fitPoissonGLM(tt.observations); err == nil {
+			t.Errorf("%s: fitPoissonGLM returned no error", tt.name)
+		}
+	}
+}
+
+func TestPoissonDeviance(t *testing.T) {
+	tests := []struct {
+		y, mu float64
+		want  float64
+	}{
+		{y: 0, mu: 1.5, want: 3},
+		{y: 2, mu: 2, want: 0},
+		{y: 1, mu: math.E, want: 2 * (-1 - (1 - math.E))},
+	}
+
+	for _, tt := range tests {
+		if got := poissonDeviance(tt.y, tt.mu); math.Abs(got-tt.want) > 1e-12 {
+			t.Errorf("poissonDeviance(%f, %f) = %f, want %f", tt.y, tt.mu, got, tt.want)
+		}
+	}
+}
@@ -20,6 +20,7 @@ type frontPageData struct {
 	Params            FrontPageParams
 	PositionsJSONData any
 	PageTemplateData
+	Resubmissions []Resubmission // resubmission history, for the resubmissions page
 }
 
 func (d frontPageData) AverageAgeString() string {
@@ -89,6 +90,10 @@ func (d frontPageData) IsResubmissionsPage() bool {
 	return d.Ranking == "resubmissions"
 }
 
+func (d frontPageData) IsDiscussionPage() bool {
+	return d.Ranking == "discussion"
+}
+
 func (d frontPageData) IsScorePage() bool {
 	return false
 }
@@ -185,8 +190,41 @@ func (p FrontPageParams) String() string {
 
 var defaultFrontPageParams = FrontPageParams{defaultModelParams, 5.0, 1.4, 2.5, 0}
 
+// frontPageRankings lists every ranking that can be served by
+// getFrontPageStories.
+var frontPageRankings = []string{
+	"hntop",
+	"new",
+	"best",
+	"ask",
+	"show",
+	"raw",
+	"fair",
+	"upvoterate",
+	"best-upvoterate",
+	"penalties",
+	"boosts",
+	"resubmissions",
+	"discussion",
+}
+
+// frontPageSampleTime returns the sampleTime of the crawl that a front page
+// with the given parameters is generated from: the latest crawl, or with
+// pastTime, the latest crawl at or before pastTime. Resolve it once per
+// request and pass it to getFrontPageData, so that a crawl finishing in
+// between doesn't mix two crawls in one response.
+func (app app) frontPageSampleTime(params FrontPageParams) (int64, error) {
+	if params.PastTime > 0 {
+		sampleTime, err := app.ndb.selectCrawlTimeBefore(params.PastTime)
+		return sampleTime, errors.Wrap(err, "selectCrawlTimeBefore")
+	}
+
+	sampleTime, err := app.ndb.selectLastCrawlTime()
+	return int64(sampleTime), errors.Wrap(err, "selectLastCrawlTime")
+}
+
 const pageSQL = `
-	with parameters as (select %f as priorWeight, %f as overallPriorWeight, %f as gravity, %f as fatigueFactor, %d as pastTime)
+	with parameters as (select %f as priorWeight, %f as overallPriorWeight, %f as gravity, %f as fatigueFactor)
 	select
 		id
 		, by
@@ -199,6 +237,8 @@ const pageSQL = `
 		, descendants
 		, cumulativeUpvotes
 		, cumulativeExpectedUpvotes
+		, cumulativeComments
+		, cumulativeExpectedComments
 		-- , (cumulativeUpvotes + priorWeight)/((1-exp(-fatigueFactor*cumulativeExpectedUpvotes))/fatigueFactor + priorWeight) as quality
 		, topRank
 		, qnRank
@@ -207,7 +247,7 @@ const pageSQL = `
 		, dupe
 		, job
 	from dataset join stories using (id) join parameters
-	where sampleTime = case when pastTime > 0 then pastTime else (select max(sampleTime) from dataset) end
+	where sampleTime = ?
 	and (%s)
 	order by %s
 	limit 90;
@@ -218,7 +258,12 @@ var statements map[string]*sql.Stmt
 func (app app) serveFrontPage(r *http.Request, w http.ResponseWriter, ranking string, p FrontPageParams) error {
 	userID := app.getUserID(r)
 
-	d, err := app.getFrontPageData(r.Context(), ranking, p, userID)
+	sampleTime, err := app.frontPageSampleTime(p)
+	if err != nil {
+		return errors.Wrap(err, "frontPageSampleTime")
+	}
+
+	d, err := app.getFrontPageData(r.Context(), ranking, p, sampleTime, userID)
 	if err != nil {
 		return errors.Wrap(err, "getFrontPageData")
 	}
@@ -230,12 +275,12 @@ func (app app) serveFrontPage(r *http.Request, w http.ResponseWriter, ranking st
 	return nil
 }
 
-func (app app) getFrontPageData(ctx context.Context, ranking string, params FrontPageParams, userID sql.NullInt64) (frontPageData, error) {
+func (app app) getFrontPageData(ctx context.Context, ranking string, params FrontPageParams, sampleTime int64, userID sql.NullInt64) (frontPageData, error) {
 	ndb := app.ndb
 
-	var sampleTime int64 = time.Now().Unix()
+	now := time.Now().Unix()
 
-	stories, err := getFrontPageStories(ctx, ndb, ranking, params)
+	stories, err := getFrontPageStories(ctx, ndb, ranking, params, sampleTime)
 	if err != nil {
 		return frontPageData{}, errors.Wrap(err, "getFrontPageStories")
 	}
@@ -261,11 +306,18 @@ func (app app) getFrontPageData(ctx context.Context, ranking string, params Fron
 	var weightedAverageQuality float64
 	var totalUpvotes int
 	for zeroBasedRank, s := range stories {
-		totalAgeSeconds += (sampleTime - s.SubmissionTime)
+		totalAgeSeconds += (now - s.SubmissionTime)
 		weightedAverageQuality += expectedUpvoteShare(0, zeroBasedRank+1) * s.UpvoteRate
 		totalUpvotes += s.Score - 1
 	}
 
+	// avoid NaN averages (which can't be marshaled to JSON) when a page is empty
+	var averageAge, averageUpvotes float64
+	if nStories > 0 {
+		averageAge = float64(totalAgeSeconds) / float64(nStories)
+		averageUpvotes = float64(totalUpvotes) / float64(nStories)
+	}
+
 	var positions any = []any{}
 
 	if userID.Valid {
@@ -310,20 +362,33 @@ func (app app) getFrontPageData(ctx context.Context, ranking string, params Fron
 		}
 	}
 
+	var resubmissions []Resubmission
+	if ranking == "resubmissions" {
+		resubmissions, err = app.ndb.selectResubmissions(ctx, 0)
+		if err != nil {
+			return frontPageData{}, errors.Wrap(err, "selectResubmissions")
+		}
+	}
+
 	d := frontPageData{
 		storyTemplates,
-		float64(totalAgeSeconds) / float64(nStories),
+		averageAge,
 		weightedAverageQuality,
-		float64(totalUpvotes) / float64(nStories),
+		averageUpvotes,
 		params,
 		positions,
 		pageTemplate,
+		resubmissions,
 	}
 
 	return d, nil
 }
 
 func orderByStatement(ranking string) string {
+	if formula, ok := formulaName(ranking); ok {
+		return fmt.Sprintf("(select rank from formula_ranks f where f.formula = '%s' and f.sampleTime = dataset.sampleTime and f.id = dataset.id)", formula)
+	}
+
 	switch ranking {
 	case "fair":
 		return `case 
@@ -352,12 +417,19 @@ func orderByStatement(ranking string) string {
 		return "pow((sampleTime-submissionTime) * (ifnull(topRank,91) - rawRank), 0.8) / pow(cast(sampleTime-submissionTime as real)/3600+2,0.8) desc nulls last"
 	case "resubmissions":
 		return "submissionTime desc"
+	case "discussion":
+		// The qn ranking formula but substituting the comment rate for upvoteRate
+		return "pow(cast(sampleTime-submissionTime as real)/3600 * (cumulativeComments + overallPriorWeight)/(cumulativeExpectedComments + overallPriorWeight), 0.8) / pow(cast(sampleTime-submissionTime as real)/3600 + 2, gravity/0.8) desc"
 	default:
 		return fmt.Sprintf("%sRank nulls last", ranking)
 	}
 }
 
 func whereClause(ranking string) string {
+	if formula, ok := formulaName(ranking); ok {
+		return fmt.Sprintf("id in (select id from formula_ranks f where f.formula = '%s' and f.sampleTime = dataset.sampleTime)", formula)
+	}
+
 	switch ranking {
 	case "fair":
 		return `
@@ -375,12 +447,17 @@ func whereClause(ranking string) string {
 		return "ifnull(topRank,91) > rawRank"
 	case "resubmissions":
 		return "submissionTime != timestamp"
+	case "discussion":
+		// only stories that are accumulating attention, as for qnRank
+		return "job = 0 and coalesce(topRank, newRank, bestRank, askRank, showRank) is not null"
 	default:
 		return "1 = 1"
 	}
 }
 
-func getFrontPageStories(ctx context.Context, ndb newsDatabase, ranking string, params FrontPageParams) (stories []Story, err error) {
+// getFrontPageStories returns the stories for a ranking from the crawl at
+// sampleTime (see frontPageSampleTime).
+func getFrontPageStories(ctx context.Context, ndb newsDatabase, ranking string, params FrontPageParams, sampleTime int64) (stories []Story, err error) {
 
 	if statements == nil {
 		statements = make(map[string]*sql.Stmt)
@@ -388,29 +465,35 @@ func getFrontPageStories(ctx context.Context, ndb newsDatabase, ranking string,
 
 	var s *sql.Stmt
 
+	// The sampleTime is a query argument, so pages for past crawls can use
+	// the statements prepared for the default parameters.
+	custom := params
+	custom.PastTime = defaultFrontPageParams.PastTime
+	isDefault := custom == defaultFrontPageParams
+
 	// Prepare statement if it hasn't already been prepared or if we are using
 	// custom parameters
-	if statements[ranking] == nil || params != defaultFrontPageParams {
+	if statements[ranking] == nil || !isDefault {
 
 		var sql string
 		orderBy := orderByStatement(ranking)
 		where := whereClause(ranking)
 
-		sql = fmt.Sprintf(pageSQL, params.PriorWeight, params.OverallPriorWeight, params.Gravity, params.FatigueFactor, params.PastTime, where, orderBy)
+		sql = fmt.Sprintf(pageSQL, params.PriorWeight, params.OverallPriorWeight, params.Gravity, params.FatigueFactor, where, orderBy)
 
 		s, err = ndb.db.Prepare(sql)
 		if err != nil {
 			return stories, errors.Wrap(err, "preparing SQL")
 		}
 
-		if params == defaultFrontPageParams {
+		if isDefault {
 			statements[ranking] = s
 		}
 	} else {
 		s = statements[ranking]
 	}
 
-	rows, err := s.QueryContext(ctx)
+	rows, err := s.QueryContext(ctx, sampleTime)
 	if err != nil {
 		return stories, errors.Wrap(err, "executing front page SQL")
 	}
@@ -420,9 +503,10 @@ func getFrontPageStories(ctx context.Context, ndb newsDatabase, ranking string,
 
 		var s Story
 
-		err = rows.Scan(&s.ID, &s.By, &s.Title, &s.URL, &s.SubmissionTime, &s.OriginalSubmissionTime, &s.AgeApprox, &s.Score, &s.Comments, &s.CumulativeUpvotes, &s.CumulativeExpectedUpvotes, &s.TopRank, &s.QNRank, &s.RawRank, &s.Flagged, &s.Dupe, &s.Job)
+		err = rows.Scan(&s.ID, &s.By, &s.Title, &s.URL, &s.SubmissionTime, &s.OriginalSubmissionTime, &s.AgeApprox, &s.Score, &s.Comments, &s.CumulativeUpvotes, &s.CumulativeExpectedUpvotes, &s.CumulativeComments, &s.CumulativeExpectedComments, &s.TopRank, &s.QNRank, &s.RawRank, &s.Flagged, &s.Dupe, &s.Job)
 
 		s.UpvoteRate = params.ModelParams.upvoteRate(s.CumulativeUpvotes, float64(s.CumulativeExpectedUpvotes))
+		s.CommentRate = params.ModelParams.commentRate(s.CumulativeComments, s.CumulativeExpectedComments)
 
 		if err != nil {
 			return stories, errors.Wrap(err, "Scanning row")
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"testing"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestFrontPageSampleTime(t *testing.T) {
+	ndb, err := openNewsDatabase(t.TempDir(), slog.New(slog.NewTextHandler(io.Discard)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ndb.close()
+
+	app := app{ndb: ndb}
+
+	// no crawls yet
+	if got, err := app.frontPageSampleTime(defaultFrontPageParams); err != nil || got != 0 {
+		t.Errorf("frontPageSampleTime without crawls = %d, %v, want 0", got, err)
+	}
+
+	// a story on the top page in crawls at 1000 and 1060
+	if _, err := ndb.db.Exec(`insert into stories(id, by, title, url, timestamp) values (1, 'user', 'Story', '', 900)`); err != nil {
+		t.Fatal(err)
+	}
+	for _, sampleTime := range []int64{1000, 1060} {
+		_, err := ndb.db.Exec(`
+			insert into dataset(id, score, descendants, sampleTime, submissionTime, topRank, ageApprox)
+			values (1, 10, 2, ?, 900, 1, 100)`, sampleTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pastTime int64
+		want     int64
+	}{
+		{pastTime: 0, want: 1060},
+		{pastTime: 1060, want: 1060},
+		{pastTime: 1059, want: 1000},
+		{pastTime: 5000, want: 1060},
+		{pastTime: 999, want: 0},
+	}
+
+	for _, tt := range tests {
+		p := defaultFrontPageParams
+		p.PastTime = tt.pastTime
+
+		sampleTime, err := app.frontPageSampleTime(p)
+		if err != nil {
+			t.Fatalf("pastTime %d: frontPageSampleTime returned error: %v", tt.pastTime, err)
+		}
+		if sampleTime != tt.want {
+			t.Errorf("pastTime %d: sampleTime = %d, want %d", tt.pastTime, sampleTime, tt.want)
+		}
+
+		// the page is generated from the resolved crawl, even if pastTime
+		// is not the time of a crawl
+		d, err := app.getFrontPageData(context.Background(), "hntop", p, sampleTime, sql.NullInt64{})
+		if err != nil {
+			t.Fatalf("pastTime %d: getFrontPageData returned error: %v", tt.pastTime, err)
+		}
+		wantStories := 1
+		if tt.want == 0 {
+			wantStories = 0
+		}
+		if len(d.Stories) != wantStories {
+			t.Errorf("pastTime %d: %d stories, want %d", tt.pastTime, len(d.Stories), wantStories)
+		}
+	}
+}
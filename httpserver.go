@@ -58,9 +58,28 @@ func (app app) httpServer(onPanic func(error)) *http.Server {
 	router.GET("/penalties", middleware("penalties", l, onPanic, app.frontpageHandler("penalties")))
 	router.GET("/boosts", middleware("boosts", l, onPanic, app.frontpageHandler("boosts")))
 	router.GET("/resubmissions", middleware("resubmissions", l, onPanic, app.frontpageHandler("resubmissions")))
+	router.GET("/discussion", middleware("discussion", l, onPanic, app.frontpageHandler("discussion")))
+	for _, ranking := range frontPageRankings {
+		router.GET("/api/v1/"+ranking, middleware("api-"+ranking, l, onPanic, app.frontpageAPIHandler(ranking)))
+		router.GET("/feeds/"+ranking+".atom", middleware("atom-"+ranking, l, onPanic, app.feedHandler(ranking, "atom")))
+		router.GET("/feeds/"+ranking+".rss", middleware("rss-"+ranking, l, onPanic, app.feedHandler(ranking, "rss")))
+	}
+
+	for _, f := range app.rankingFormulas {
+		router.GET("/"+f.ranking(), middleware(f.ranking(), l, onPanic, app.formulaPageHandler(f)))
+	}
+
 	router.GET("/stats", middleware("stats", l, onPanic, app.statsHandler()))
 	router.GET("/about", middleware("about", l, onPanic, app.aboutHandler()))
 	router.GET("/algorithms", middleware("algorithms", l, onPanic, app.algorithmsHandler()))
+	router.GET("/events", middleware("events", l, onPanic, app.eventsHandler()))
+	router.GET("/crawls", middleware("crawls", l, onPanic, app.crawlsHandler()))
+	router.GET("/api/v1/crawls", middleware("api-crawls", l, onPanic, app.crawlsAPIHandler()))
+	router.GET("/parse-health", middleware("parse-health", l, onPanic, app.parseHealthHandler()))
+	router.GET("/api/v1/parse-health", middleware("api-parse-health", l, onPanic, app.parseHealthAPIHandler()))
+	router.GET("/search", middleware("search", l, onPanic, app.searchHandler()))
+	router.GET("/api/v1/search", middleware("api-search", l, onPanic, app.storySearchAPIHandler()))
+	router.GET("/api/v1/items/search", middleware("api-items-search", l, onPanic, app.storyItemsSearchHandler()))
 
 	router.POST("/vote", middleware("upvote", l, onPanic, app.voteHandler()))
 
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/johnwarden/hn"
+	"golang.org/x/exp/slog"
+)
+
+// The details of stories that weren't scraped come from the API, one
+// request per item. Most of these items don't change from one crawl to the
+// next, so they are cached. Items listed in the API's updates feed, which
+// lists recently changed items, are fetched again, and so are items
+// fetched more than itemCacheTTL ago, since the updates feed only covers a
+// short window and a change could slip between two crawls. If the updates
+// feed can't be fetched, all items are fetched again.
+//
+// The cache only serves the stories that weren't scraped. Reconciliation
+// (see reconcileScrapedStories) always fetches fresh items, since it
+// measures how far the API lags behind the scraped pages, but the items it
+// fetches are added to the cache.
+//
+// A cached score is up to itemCacheTTL seconds old, so a story's upvotes
+// don't show up while its item is served from the cache, and then show up
+// at once when it is fetched again. To keep this from skewing the sitewide
+// upvotes and comments, stories are left out of the sitewide sums in the
+// crawls where their score was served from the cache, and in the first
+// crawl after that (see staleScore).
+
+// Cached items are fetched again after this many seconds, which bounds how
+// stale the score of a story can be.
+const itemCacheTTL = 5 * 60
+
+type cachedItem struct {
+	item hn.Item
+	// sampleTime of the crawl that fetched the item
+	fetched int64
+}
+
+// An itemCache is shared by all crawls of the app. A nil *itemCache
+// doesn't cache anything.
+type itemCache struct {
+	mu    sync.Mutex
+	items map[int]cachedItem
+	// ids of the items served from the cache by the latest call to
+	// getItems, and by the call before that
+	stale           map[int]bool
+	previouslyStale map[int]bool
+}
+
+func newItemCache() *itemCache {
+	return &itemCache{items: make(map[int]cachedItem), stale: make(map[int]bool)}
+}
+
+// getItems returns the items with the given ids, in the same order, like
+// RankSource.GetItems. Items that are in the cache and haven't been
+// updated are not fetched again. Items served from the cache are added
+// to the crawl capture, if any, so that captured crawls can be replayed
+// without the cache.
+func (c *itemCache) getItems(ctx context.Context, source RankSource, ids []int, sampleTime int64, logger *slog.Logger) ([]hn.Item, error) {
+	if c == nil {
+		return source.GetItems(ctx, ids, maxGoroutines)
+	}
+
+	updated := make(map[int]bool)
+	fullRefresh := false
+	if updates, err := source.Updates(ctx); err != nil {
+		logger.Warn("Failed to fetch updates feed. Fetching all items", "err", err)
+		itemCacheUpdatesErrorsTotal.Inc()
+		fullRefresh = true
+	} else {
+		for _, id := range updates.Items {
+			updated[id] = true
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evict(sampleTime)
+	c.previouslyStale, c.stale = c.stale, make(map[int]bool)
+
+	items := make([]hn.Item, len(ids))
+	var cached []hn.Item
+	var toFetch []int
+	var toFetchIndexes []int
+	for i, id := range ids {
+		if entry, ok := c.items[id]; ok && !fullRefresh && !updated[id] {
+			items[i] = entry.item
+			cached = append(cached, entry.item)
+			c.stale[id] = true
+			continue
+		}
+		toFetch = append(toFetch, id)
+		toFetchIndexes = append(toFetchIndexes, i)
+	}
+
+	Debugf(logger, "Item cache: %d cached, %d to fetch, full refresh: %v", len(cached), len(toFetch), fullRefresh)
+	itemCacheHitsTotal.Add(len(cached))
+	itemCacheMissesTotal.Add(len(toFetch))
+	captureItems(source, cached)
+
+	if len(toFetch) == 0 {
+		return items, nil
+	}
+
+	fetched, err := source.GetItems(ctx, toFetch, maxGoroutines)
+	for i, item := range fetched {
+		items[toFetchIndexes[i]] = item
+	}
+	c.store(fetched, sampleTime)
+
+	return items, err
+}
+
+// staleScore reports whether the score of a story in the latest crawl, or
+// in the crawl before, was served from the cache. If so, the difference to
+// the score of the previous crawl doesn't tell how many upvotes the story
+// got since then.
+func (c *itemCache) staleScore(id int) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stale[id] || c.previouslyStale[id]
+}
+
+// add adds items fetched outside of getItems to the cache.
+func (c *itemCache) add(items []hn.Item, sampleTime int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.store(items, sampleTime)
+}
+
+// store must be called with c.mu held.
+func (c *itemCache) store(items []hn.Item, sampleTime int64) {
+	for _, item := range items {
+		// items that failed to download are left empty
+		if item.ID != 0 {
+			c.items[item.ID] = cachedItem{item, sampleTime}
+		}
+	}
+}
+
+// evict removes the items that are too old to be served. It must be called
+// with c.mu held.
+func (c *itemCache) evict(sampleTime int64) {
+	for id, entry := range c.items {
+		if sampleTime-entry.fetched >= itemCacheTTL {
+			delete(c.items, id)
+		}
+	}
+}
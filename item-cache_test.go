@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/johnwarden/hn"
+	"golang.org/x/exp/slog"
+)
+
+// itemSource is a RankSource that serves items with a given score and
+// counts the items fetched.
+type itemSource struct {
+	RankSource
+	score   int
+	updated []int
+	fetched *int
+}
+
+func (s itemSource) GetItems(ctx context.Context, ids []int, maxGoroutines int) ([]hn.Item, error) {
+	items := make([]hn.Item, len(ids))
+	for i, id := range ids {
+		items[i] = hn.Item{ID: id, Type: "story", Score: s.score}
+	}
+	*s.fetched += len(ids)
+	return items, nil
+}
+
+func (s itemSource) Updates(ctx context.Context) (*hn.Updates, error) {
+	return &hn.Updates{Items: s.updated}, nil
+}
+
+func TestItemCacheStaleScore(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard))
+	c := newItemCache()
+
+	tests := []struct {
+		name       string
+		sampleTime int64
+		updated    []int
+		// whether item 1 is fetched rather than served from the cache
+		fetched   bool
+		score     int
+		wantStale bool
+	}{
+		{name: "first fetch", sampleTime: 1000, fetched: true, score: 10, wantStale: false},
+		{name: "cached", sampleTime: 1060, fetched: false, score: 10, wantStale: true},
+		{name: "still cached", sampleTime: 1120, fetched: false, score: 10, wantStale: true},
+		{name: "updated after cached", sampleTime: 1180, updated: []int{1}, fetched: true, score: 20, wantStale: true},
+		{name: "updated again", sampleTime: 1240, updated: []int{1}, fetched: true, score: 25, wantStale: false},
+		{name: "cached again", sampleTime: 1300, fetched: false, score: 25, wantStale: true},
+		{name: "expired", sampleTime: 1540, fetched: true, score: 40, wantStale: true},
+		{name: "fresh after expiry", sampleTime: 1600, updated: []int{1}, fetched: true, score: 41, wantStale: false},
+	}
+
+	for _, tt := range tests {
+		var fetched int
+		source := itemSource{score: tt.score, updated: tt.updated, fetched: &fetched}
+
+		items, err := c.getItems(context.Background(), source, []int{1}, tt.sampleTime, logger)
+		if err != nil {
+			t.Fatalf("%s: getItems returned error: %v", tt.name, err)
+		}
+		if got := fetched == 1; got != tt.fetched {
+			t.Errorf("%s: fetched = %t, want %t", tt.name, got, tt.fetched)
+		}
+		if items[0].Score != tt.score {
+			t.Errorf("%s: score = %d, want %d", tt.name, items[0].Score, tt.score)
+		}
+		if got := c.staleScore(1); got != tt.wantStale {
+			t.Errorf("%s: staleScore = %t, want %t", tt.name, got, tt.wantStale)
+		}
+		if c.staleScore(2) {
+			t.Errorf("%s: staleScore of an item that was never served = true", tt.name)
+		}
+	}
+
+	// without a cache, every score is fresh
+	var nilCache *itemCache
+	if nilCache.staleScore(1) {
+		t.Errorf("staleScore without a cache = true")
+	}
+}
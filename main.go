@@ -58,6 +58,11 @@ func main() {
 		return
 	}
 
+	// Run a command-line tool instead of the server
+	if len(os.Args) > 1 && runCommand(os.Args[1:]) {
+		return
+	}
+
 	app := initApp()
 	defer app.cleanup()
 
@@ -164,6 +169,9 @@ func main() {
 	// Start the vacuum worker (runs Sunday early morning)
 	go app.vacuumWorker(ctx)
 
+	// Start the domain penalty worker (runs every 6 hours)
+	go app.domainPenaltyWorker(ctx)
+
 	app.mainLoop(ctx)
 }
 
@@ -180,73 +188,85 @@ func (app app) mainLoop(ctx context.Context) {
 	elapsed := int(t) - lastCrawlTime
 
 	// If it has been more than a minute since our last crawl,
-	// then crawl right away.
-	if elapsed >= 60 {
+	// then crawl right away. Otherwise wait for the next minute mark.
+	var plannedTime int64
+	if elapsed >= crawlInterval {
 		logger.Info("60 seconds since last crawl. Crawling now.")
-		if err = app.crawlAndPostprocess(ctx); err != nil {
-			logger.Error("crawlAndPostprocess", err)
+		plannedTime = t
+	} else {
+		logger.Info("Less than 60 seconds since last crawl.", "waitSeconds", crawlInterval-t%crawlInterval)
+		plannedTime = (t/crawlInterval + 1) * crawlInterval
+	}
+
+	var missedTicks int
+	firstCrawl := true
 
-			if errors.Is(err, context.Canceled) {
+	// releases the idle context sent to the purge worker, once the next
+	// crawl begins (it expires by its deadline before that anyway)
+	cancelIdle := func() {}
+	defer func() { cancelIdle() }()
+
+	for {
+		// We use this instead of using time.NewTicker because in dev mode our
+		// app can be suspended, and I want to see all the timestamps in the DB
+		// as multiples of 60.
+		if delay := time.Until(time.Unix(plannedTime, 0)); delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
 				return
 			}
 		}
-	} else {
-		logger.Info("Less than 60 seconds since last crawl.", "waitSeconds", 60-time.Now().Unix()%60)
-	}
 
-	// And now set a ticker so we crawl every minute going forward
-	ticker := make(chan int64)
+		cancelIdle()
 
-	// Make the first tick happen at the next
-	// Minute mark.
-	go func() {
-		t := time.Now().Unix()
-		delay := 60 - t%60
-		<-time.After(time.Duration(delay) * time.Second)
-		ticker <- t + delay
-	}()
+		sampleTime := time.Now().Unix()
 
-	for {
-		select {
-		case <-ticker:
-			t := time.Now().Unix()
-			// Set the next tick at the minute mark. We use this instead of using
-			// time.NewTicker because in dev mode our app can be suspended, and I
-			// want to see all the timestamps in the DB as multiples of 60.
-			delay := 60 - t%60
-			nextTickTime := t + delay
-			go func() {
-				<-time.After(time.Duration(delay) * time.Second)
-				ticker <- nextTickTime
-			}()
-
-			logger.Info("Beginning crawl")
-
-			// Create a context with deadline for both crawl and idle period
-			crawlCtx, cancel := context.WithDeadline(ctx, time.Unix(nextTickTime-1, 0))
-			defer cancel()
+		// The first crawl has no previous crawl that could have overrun
+		if sampleTime > plannedTime && !firstCrawl {
+			logger.Warn("Previous crawl overran the next minute mark. Catching up", "missedTicks", missedTicks, "latency", sampleTime-plannedTime)
+			crawlMissedTicksTotal.Add(missedTicks)
+		}
 
-			if err = app.crawlAndPostprocess(crawlCtx); err != nil {
-				logger.Error("crawlAndPostprocess", err)
-			} else {
-				app.logger.Info("Finished crawl and postprocess")
-
-				// Only send idle context if we have enough time (at least 5 seconds)
-				if delay >= 5 {
-					// Try to send the context to the purge worker (non-blocking)
-					select {
-					case app.archiveTriggerChan <- crawlCtx:
-						app.logger.Debug("Sent idle context to purge worker", "available_seconds", delay)
-					default:
-						app.logger.Warn("Purge trigger channel full, signal dropped - purge worker may be backed up")
-					}
-				} else {
-					app.logger.Debug("Skipping idle context - not enough time", "delay", delay)
+		logger.Info("Beginning crawl")
+
+		// Create a context with deadline for both crawl and idle period
+		deadline := crawlDeadline(sampleTime)
+		crawlCtx, cancel := context.WithDeadline(ctx, time.Unix(deadline, 0))
+
+		crawlApp := app
+		crawlApp.sampleTime = sampleTime
+
+		err := crawlApp.crawlAndRecord(crawlCtx, plannedTime, missedTicks)
+		cancel()
+		firstCrawl = false
+
+		plannedTime, missedTicks = nextCrawl(plannedTime, time.Now().Unix())
+
+		if err != nil {
+			logger.Error("crawlAndPostprocess", err)
+			if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+				return
+			}
+		} else {
+			app.logger.Info("Finished crawl and postprocess")
+
+			// Only send idle context if we have enough time (at least 5 seconds)
+			if delay := plannedTime - 1 - time.Now().Unix(); delay >= 5 {
+				// The purge worker can use the time until the next crawl
+				idleCtx, idleCancel := context.WithDeadline(ctx, time.Unix(plannedTime-1, 0))
+				cancelIdle = idleCancel
+
+				// Try to send the context to the purge worker (non-blocking)
+				select {
+				case app.archiveTriggerChan <- idleCtx:
+					app.logger.Debug("Sent idle context to purge worker", "available_seconds", delay)
+				default:
+					app.logger.Warn("Purge trigger channel full, signal dropped - purge worker may be backed up")
 				}
+			} else {
+				app.logger.Debug("Skipping idle context - not enough time", "delay", delay)
 			}
-
-		case <-ctx.Done():
-			return
 		}
 	}
 }
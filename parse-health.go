@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// The scraper depends on the markup of Hacker News pages (see rawStory).
+// Stories that fail to parse silently fall back to the API, which doesn't
+// tell whether they are flagged or dupes. So every crawl tallies, per page
+// type, how many story rows were parsed and how many of those failed for
+// each field, and keeps the HTML of a few failing rows. When HN changes its
+// markup, the success rate of the affected fields drops, and an alert is
+// raised when it falls below the threshold (PARSE_HEALTH_THRESHOLD).
+//
+// The "story" field is the share of stories ranked on a page according to
+// the API that were scraped successfully. It catches changes that make the
+// scraper miss rows altogether.
+
+// storyFields are the fields of a story row parsed by rawStory.Clean
+var storyFields = []string{"id", "title", "url", "by", "score", "submissionTime", "ageApprox", "rank", "comments"}
+
+// parseHealthFields are the fields recorded in the parse_health table
+var parseHealthFields = append([]string{"story"}, storyFields...)
+
+const (
+	defaultParseHealthThreshold = 0.9
+
+	// the number of failing rows kept per page type and field in each crawl
+	maxParseFailureSamples = 3
+	maxParseFailureHTML    = 4000
+
+	parseHealthRetention   = 30 * 24 * 60 * 60
+	parseFailuresRetention = 7 * 24 * 60 * 60
+)
+
+// parseParseHealthThreshold parses the PARSE_HEALTH_THRESHOLD setting, a
+// success rate between 0 and 1.
+func parseParseHealthThreshold(s string) (float64, error) {
+	if s == "" {
+		return defaultParseHealthThreshold, nil
+	}
+	threshold, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing parse health threshold %s", s)
+	}
+	if threshold < 0 || threshold > 1 {
+		return 0, fmt.Errorf("parse health threshold %s is not between 0 and 1", s)
+	}
+	return threshold, nil
+}
+
+type fieldTally struct {
+	Attempts int
+	Failures int
+}
+
+// A ParseFailure is a story row in which a field failed to parse.
+type ParseFailure struct {
+	SampleTime int64
+	PageType   string
+	Field      string
+	// the id attribute of the row, which may itself have failed to parse
+	StoryID string
+	Error   string
+	HTML    string
+}
+
+func (f ParseFailure) SampleTimeISOString() string {
+	return time.Unix(f.SampleTime, 0).UTC().Format("2006-01-02T15:04:05")
+}
+
+// A pageParseReport tallies the story rows parsed on one page type in a
+// crawl. Each page type is scraped in a single goroutine, so it needs no
+// locking.
+type pageParseReport struct {
+	Fields   map[string]fieldTally
+	Failures []ParseFailure
+
+	// the number of stories ranked on the page according to the API, and
+	// the number of those scraped successfully
+	Ranked  int
+	Scraped int
+}
+
+func newPageParseReport() *pageParseReport {
+	return &pageParseReport{Fields: make(map[string]fieldTally, len(storyFields))}
+}
+
+// record counts a story row, with the error returned by rawStory.Clean.
+func (r *pageParseReport) record(pageType string, rs rawStory, err error) {
+	fieldErrors, _ := err.(storyParseError)
+
+	for _, field := range storyFields {
+		t := r.Fields[field]
+		t.Attempts++
+		if fieldErr, ok := fieldErrors[field]; ok {
+			t.Failures++
+			if t.Failures <= maxParseFailureSamples {
+				r.Failures = append(r.Failures, ParseFailure{
+					PageType: pageType,
+					Field:    field,
+					StoryID:  rs.ID,
+					Error:    fieldErr.Error(),
+					HTML:     truncateHTML(rs.html),
+				})
+			}
+		}
+		r.Fields[field] = t
+	}
+}
+
+func truncateHTML(s string) string {
+	if len(s) <= maxParseFailureHTML {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxParseFailureHTML], "") + "…"
+}
+
+// A parseReport holds the pageParseReport of every page type, or nil for
+// page types that weren't scraped.
+type parseReport [nPageTypes]*pageParseReport
+
+// countRanked counts the stories ranked on each page according to the API.
+func (report parseReport) countRanked(storyRanks map[int]ranksArray) {
+	for pageType, r := range report {
+		if r == nil {
+			continue
+		}
+		for _, ranks := range storyRanks {
+			if ranks[pageType] != 0 {
+				r.Ranked++
+			}
+		}
+	}
+}
+
+// A parseHealthRecord is one row of the parse_health table.
+type parseHealthRecord struct {
+	SampleTime int64
+	PageType   string
+	Field      string
+	fieldTally
+}
+
+// SuccessRate is the share of attempts that parsed, or 1 if there were
+// none.
+func (h parseHealthRecord) SuccessRate() float64 {
+	if h.Attempts == 0 {
+		return 1
+	}
+	return float64(h.Attempts-h.Failures) / float64(h.Attempts)
+}
+
+func (h parseHealthRecord) SuccessRateString() string {
+	if h.Attempts == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", h.SuccessRate()*100)
+}
+
+func (h parseHealthRecord) SampleTimeISOString() string {
+	return time.Unix(h.SampleTime, 0).UTC().Format("2006-01-02T15:04:05")
+}
+
+// records returns the parse health records of the report.
+func (report parseReport) records(sampleTime int64) []parseHealthRecord {
+	var records []parseHealthRecord
+	for pageType, r := range report {
+		if r == nil {
+			continue
+		}
+		pageTypeName := pageTypes[pageTypeInt(pageType)]
+
+		stories := fieldTally{Attempts: r.Ranked, Failures: max(r.Ranked-r.Scraped, 0)}
+		records = append(records, parseHealthRecord{sampleTime, pageTypeName, "story", stories})
+
+		for _, field := range storyFields {
+			records = append(records, parseHealthRecord{sampleTime, pageTypeName, field, r.Fields[field]})
+		}
+	}
+	return records
+}
+
+func (ndb newsDatabase) insertParseHealth(ctx context.Context, sampleTime int64, report parseReport) error {
+	tx, err := ndb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "BeginTx")
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, h := range report.records(sampleTime) {
+		_, err := tx.Exec(`
+			insert into parse_health (sampleTime, pageType, field, attempts, failures) values (?, ?, ?, ?, ?)
+			on conflict do nothing
+		`, h.SampleTime, h.PageType, h.Field, h.Attempts, h.Failures)
+		if err != nil {
+			return errors.Wrap(err, "inserting parse health")
+		}
+	}
+
+	// Store failing rows only for fields that have none since they last
+	// parsed without failures, so a field that keeps failing during layout
+	// drift doesn't store the same HTML on every crawl.
+	hasSamples := make(map[[2]string]bool)
+	for _, r := range report {
+		if r == nil {
+			continue
+		}
+		for _, f := range r.Failures {
+			key := [2]string{f.PageType, f.Field}
+			has, ok := hasSamples[key]
+			if !ok {
+				err := tx.QueryRow(`
+					select exists(
+						select 1 from parse_failures
+						where pageType = ?1 and field = ?2
+						and sampleTime > ifnull((
+							select max(sampleTime) from parse_health
+							where pageType = ?1 and field = ?2 and failures = 0
+						), 0)
+					)
+				`, f.PageType, f.Field).Scan(&has)
+				if err != nil {
+					return errors.Wrap(err, "selecting parse failure samples")
+				}
+				hasSamples[key] = has
+			}
+			if has {
+				continue
+			}
+
+			_, err := tx.Exec(`
+				insert into parse_failures (sampleTime, pageType, field, storyID, error, html) values (?, ?, ?, ?, ?, ?)
+			`, sampleTime, f.PageType, f.Field, f.StoryID, f.Error, f.HTML)
+			if err != nil {
+				return errors.Wrap(err, "inserting parse failure")
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`delete from parse_health where sampleTime < ?`, sampleTime-parseHealthRetention); err != nil {
+		return errors.Wrap(err, "deleting old parse health")
+	}
+	if _, err := tx.Exec(`delete from parse_failures where sampleTime < ?`, sampleTime-parseFailuresRetention); err != nil {
+		return errors.Wrap(err, "deleting old parse failures")
+	}
+
+	return errors.Wrap(tx.Commit(), "commit")
+}
+
+// recordParseHealth stores the parse report of the crawl at sampleTime,
+// updates the parse_success_rate metrics, and raises an alert for every
+// field whose success rate is below the threshold.
+func (app app) recordParseHealth(ctx context.Context, sampleTime int64, report parseReport) {
+	records := report.records(sampleTime)
+	if len(records) == 0 {
+		// the crawl failed before scraping
+		return
+	}
+
+	if err := app.ndb.insertParseHealth(ctx, sampleTime, report); err != nil {
+		app.logger.Error("insertParseHealth", err)
+	}
+
+	for _, h := range records {
+		setParseSuccessRate(h.PageType, h.Field, h.SuccessRate())
+
+		if h.Attempts > 0 && h.SuccessRate() < app.parseHealthThreshold {
+			parseHealthAlertsTotal.Inc()
+			app.logger.Error("Parse success rate below threshold",
+				fmt.Errorf("%d of %d failed to parse", h.Failures, h.Attempts),
+				"pageType", h.PageType, "field", h.Field,
+				"successRate", h.SuccessRate(), "threshold", app.parseHealthThreshold)
+		}
+	}
+}
+
+// selectParseHealth returns the parse health of the crawls of the last
+// hours, latest first. With hours = 0, it returns the latest crawl.
+func (ndb newsDatabase) selectParseHealth(ctx context.Context, hours int) ([]parseHealthRecord, error) {
+	rows, err := ndb.db.QueryContext(ctx, `
+		select sampleTime, pageType, field, attempts, failures
+		from parse_health
+		where sampleTime >= (select max(sampleTime) from parse_health) - ?
+		order by sampleTime desc, pageType, field
+	`, hours*3600)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting parse health")
+	}
+	defer rows.Close()
+
+	var records []parseHealthRecord
+	for rows.Next() {
+		var h parseHealthRecord
+		if err := rows.Scan(&h.SampleTime, &h.PageType, &h.Field, &h.Attempts, &h.Failures); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		records = append(records, h)
+	}
+
+	return records, rows.Err()
+}
+
+// the number of failing rows shown on the parse health page
+const parseFailuresLimit = 30
+
+func (ndb newsDatabase) selectParseFailures(ctx context.Context, limit int) ([]ParseFailure, error) {
+	rows, err := ndb.db.QueryContext(ctx, `
+		select sampleTime, pageType, field, storyID, error, html
+		from parse_failures
+		order by sampleTime desc
+		limit ?
+	`, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting parse failures")
+	}
+	defer rows.Close()
+
+	var failures []ParseFailure
+	for rows.Next() {
+		var f ParseFailure
+		if err := rows.Scan(&f.SampleTime, &f.PageType, &f.Field, &f.StoryID, &f.Error, &f.HTML); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		failures = append(failures, f)
+	}
+
+	return failures, rows.Err()
+}
+
+type ParseHealthPageParams struct {
+	Hours int `schema:"hours"`
+}
+
+func (p ParseHealthPageParams) hours() int {
+	if p.Hours <= 0 {
+		return 24
+	}
+	return min(p.Hours, maxCrawlsPageHours)
+}
+
+// apiParseHealth is the JSON representation of a parse health record.
+type apiParseHealth struct {
+	SampleTime  int64   `json:"sampleTime"`
+	PageType    string  `json:"pageType"`
+	Field       string  `json:"field"`
+	Attempts    int     `json:"attempts"`
+	Failures    int     `json:"failures"`
+	SuccessRate float64 `json:"successRate"`
+}
+
+// parseHealthAPIHandler serves the parse health of the crawls of the last
+// hours (default 24) as JSON, latest first.
+func (app app) parseHealthAPIHandler() func(http.ResponseWriter, *http.Request, ParseHealthPageParams) error {
+	return func(w http.ResponseWriter, r *http.Request, p ParseHealthPageParams) error {
+		if err := app.requireAdmin(w, r); err != nil {
+			return err
+		}
+
+		records, err := app.ndb.selectParseHealth(r.Context(), p.hours())
+		if err != nil {
+			return errors.Wrap(err, "selectParseHealth")
+		}
+
+		result := make([]apiParseHealth, len(records))
+		for i, h := range records {
+			result[i] = apiParseHealth{h.SampleTime, h.PageType, h.Field, h.Attempts, h.Failures, h.SuccessRate()}
+		}
+
+		b, err := json.Marshal(result)
+		if err != nil {
+			return errors.Wrap(err, "marshaling parse health JSON")
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		_, err = w.Write(b)
+		return errors.Wrap(err, "writing HTTP response")
+	}
+}
+
+type ParseHealthPageData struct {
+	PageTemplateData
+	Hours     int
+	Threshold float64
+	Records   []parseHealthRecord
+	Failures  []ParseFailure
+}
+
+func (d ParseHealthPageData) IsParseHealthPage() bool {
+	return true
+}
+
+func (d ParseHealthPageData) Fields() []string {
+	return parseHealthFields
+}
+
+func (d ParseHealthPageData) ThresholdString() string {
+	return fmt.Sprintf("%.0f%%", d.Threshold*100)
+}
+
+func (d ParseHealthPageData) IsUnhealthy(h parseHealthRecord) bool {
+	return h.Attempts > 0 && h.SuccessRate() < d.Threshold
+}
+
+// A parseHealthRow is a row of the tables on the parse health page: the
+// records of one page type in one crawl, in the order of parseHealthFields.
+type parseHealthRow struct {
+	SampleTime int64
+	PageType   string
+	Fields     []parseHealthRecord
+}
+
+func (r parseHealthRow) SampleTimeISOString() string {
+	return time.Unix(r.SampleTime, 0).UTC().Format("2006-01-02T15:04:05")
+}
+
+func (d ParseHealthPageData) rows() []parseHealthRow {
+	type key struct {
+		sampleTime int64
+		pageType   string
+	}
+	byKey := make(map[key]map[string]parseHealthRecord)
+	var keys []key
+	for _, h := range d.Records {
+		k := key{h.SampleTime, h.PageType}
+		if _, ok := byKey[k]; !ok {
+			byKey[k] = make(map[string]parseHealthRecord)
+			keys = append(keys, k)
+		}
+		byKey[k][h.Field] = h
+	}
+
+	// latest first, page types in the usual order
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].sampleTime != keys[j].sampleTime {
+			return keys[i].sampleTime > keys[j].sampleTime
+		}
+		a, _ := pageTypeByName(keys[i].pageType)
+		b, _ := pageTypeByName(keys[j].pageType)
+		return a < b
+	})
+
+	rows := make([]parseHealthRow, len(keys))
+	for i, k := range keys {
+		rows[i] = parseHealthRow{SampleTime: k.sampleTime, PageType: k.pageType}
+		for _, field := range parseHealthFields {
+			h, ok := byKey[k][field]
+			if !ok {
+				h = parseHealthRecord{SampleTime: k.sampleTime, PageType: k.pageType, Field: field}
+			}
+			rows[i].Fields = append(rows[i].Fields, h)
+		}
+	}
+	return rows
+}
+
+// Latest returns the rows of the latest crawl, one per page type.
+func (d ParseHealthPageData) Latest() []parseHealthRow {
+	var latest []parseHealthRow
+	for _, row := range d.rows() {
+		if len(d.Records) > 0 && row.SampleTime == d.Records[0].SampleTime {
+			latest = append(latest, row)
+		}
+	}
+	return latest
+}
+
+// Unhealthy returns the rows of all crawls with at least one field below
+// the threshold, up to crawlsTableLimit.
+func (d ParseHealthPageData) Unhealthy() []parseHealthRow {
+	var unhealthy []parseHealthRow
+	for _, row := range d.rows() {
+		for _, h := range row.Fields {
+			if d.IsUnhealthy(h) {
+				unhealthy = append(unhealthy, row)
+				break
+			}
+		}
+		if len(unhealthy) == crawlsTableLimit {
+			break
+		}
+	}
+	return unhealthy
+}
+
+func (app app) parseHealthHandler() func(http.ResponseWriter, *http.Request, ParseHealthPageParams) error {
+	return func(w http.ResponseWriter, r *http.Request, p ParseHealthPageParams) error {
+		if err := app.requireAdmin(w, r); err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		records, err := app.ndb.selectParseHealth(r.Context(), p.hours())
+		if err != nil {
+			return errors.Wrap(err, "selectParseHealth")
+		}
+
+		failures, err := app.ndb.selectParseFailures(r.Context(), parseFailuresLimit)
+		if err != nil {
+			return errors.Wrap(err, "selectParseFailures")
+		}
+
+		d := ParseHealthPageData{
+			PageTemplateData: PageTemplateData{UserID: app.getUserID(r)},
+			Hours:            p.hours(),
+			Threshold:        app.parseHealthThreshold,
+			Records:          records,
+			Failures:         failures,
+		}
+
+		err = templates.ExecuteTemplate(w, "parseHealth.html.tmpl", d)
+		return errors.Wrap(err, "executing parse health page template")
+	}
+}
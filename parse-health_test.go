@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"golang.org/x/exp/slog"
+)
+
+func TestParseParseHealthThreshold(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    float64
+		wantErr bool
+	}{
+		{s: "", want: defaultParseHealthThreshold},
+		{s: "0.5", want: 0.5},
+		{s: "0", want: 0},
+		{s: "1", want: 1},
+		{s: "1.5", wantErr: true},
+		{s: "-0.1", wantErr: true},
+		{s: "90%", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseParseHealthThreshold(tt.s)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseParseHealthThreshold(%q) = %f, want an error", tt.s, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseParseHealthThreshold(%q) = %f, %v, want %f", tt.s, got, err, tt.want)
+		}
+	}
+}
+
+func TestInsertParseHealthSamples(t *testing.T) {
+	ndb, err := openNewsDatabase(t.TempDir(), slog.New(slog.NewTextHandler(io.Discard)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ndb.close()
+
+	// a crawl of the top page in which the score of failingRows rows
+	// failed to parse
+	crawl := func(failingRows int) parseReport {
+		r := newPageParseReport()
+		for i := 0; i < 30; i++ {
+			var err error
+			if i < failingRows {
+				err = storyParseError{"score": errors.New("missing score")}
+			}
+			r.record("top", rawStory{ID: "1", html: "<tr></tr>"}, err)
+		}
+		var report parseReport
+		report[top] = r
+		return report
+	}
+
+	tests := []struct {
+		name        string
+		failingRows int
+		wantSamples int
+	}{
+		{name: "healthy", failingRows: 0, wantSamples: 0},
+		{name: "starts failing", failingRows: 5, wantSamples: maxParseFailureSamples},
+		{name: "keeps failing", failingRows: 30, wantSamples: maxParseFailureSamples},
+		{name: "recovers", failingRows: 0, wantSamples: maxParseFailureSamples},
+		{name: "fails again", failingRows: 1, wantSamples: maxParseFailureSamples + 1},
+	}
+
+	for i, tt := range tests {
+		if err := ndb.insertParseHealth(context.Background(), int64(1_700_000_000+60*i), crawl(tt.failingRows)); err != nil {
+			t.Fatalf("%s: insertParseHealth: %v", tt.name, err)
+		}
+
+		var samples int
+		if err := ndb.db.QueryRow("select count(*) from parse_failures").Scan(&samples); err != nil {
+			t.Fatal(err)
+		}
+		if samples != tt.wantSamples {
+			t.Errorf("%s: %d failing rows stored, want %d", tt.name, samples, tt.wantSamples)
+		}
+	}
+}
@@ -14,7 +14,7 @@ import (
 )
 
 const (
-	qnRankFormulaSQL = "pow(ageHours * (cumulativeUpvotes + overallPriorWeight)/((1-exp(-fatigueFactor*cumulativeExpectedUpvotes))/fatigueFactor + overallPriorWeight), 0.8) / pow(ageHours + 2, gravity/0.8) desc"
+	qnRankFormulaSQL = "pow(ageHours * (cumulativeUpvotes + overallPriorWeight)/((1-exp(-fatigueFactor*cumulativeExpectedUpvotes))/fatigueFactor + overallPriorWeight), 0.8) / pow(ageHours + 2, gravity/0.8) * pow(1 - penalty, penaltyWeight) desc"
 
 	// qnRankFormulaSQL = `
 	// 	pow(
@@ -53,11 +53,26 @@ func (app app) crawlPostprocess(ctx context.Context, tx *sql.Tx) error {
 		}
 	}
 
+	err = app.updateResubmissions(ctx, tx)
+	if err != nil {
+		return errors.Wrap(err, "updateResubmissions")
+	}
+
 	err = app.updateQNRanks(ctx, tx)
 	if err != nil {
 		return errors.Wrap(err, "updateQNRanks")
 	}
 
+	err = app.updateFormulaRanks(ctx, tx)
+	if err != nil {
+		return errors.Wrap(err, "updateFormulaRanks")
+	}
+
+	err = app.updateEvents(ctx, tx)
+	if err != nil {
+		return errors.Wrap(err, "updateEvents")
+	}
+
 	app.logger.Info("Finished crawl postprocessing", slog.Duration("elapsed", time.Since(t)))
 
 	return err
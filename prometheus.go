@@ -4,6 +4,7 @@ import (
 	"context"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
@@ -20,6 +21,10 @@ var (
 	requestErrorsTotal          = metrics.NewCounter(`errors_total{type="request"}`)
 	crawlDuration               = metrics.NewHistogram("crawl_duration_seconds")
 	crawlPostprocessingDuration = metrics.NewHistogram("crawl_postprocessing_duration_seconds")
+	crawlLatency                = metrics.NewHistogram("crawl_latency_seconds")
+	crawlGap                    = metrics.NewHistogram("crawl_gap_seconds")
+	crawlMissedTicksTotal       = metrics.NewCounter(`crawl_missed_ticks_total`)
+	crawlsInterpolatedTotal     = metrics.NewCounter(`crawls_interpolated_total`)
 
 	upvotesTotal         = metrics.NewCounter(`upvotes_total`)
 	submissionsTotal     = metrics.NewCounter(`submissions_total`)
@@ -28,6 +33,25 @@ var (
 
 	vacuumOperationsTotal = metrics.NewCounter(`database_vacuum_operations_total{database="frontpage"}`)
 
+	parseHealthAlertsTotal = metrics.NewCounter(`parse_health_alerts_total`)
+
+	trackedSubmissionsTotal     = metrics.NewCounter(`tracked_submissions_total`)
+	submissionItemsSkippedTotal = metrics.NewCounter(`submission_items_skipped_total`)
+
+	itemCacheHitsTotal          = metrics.NewCounter(`item_cache_requests_total{result="hit"}`)
+	itemCacheMissesTotal        = metrics.NewCounter(`item_cache_requests_total{result="miss"}`)
+	itemCacheUpdatesErrorsTotal = metrics.NewCounter(`item_cache_updates_errors_total`)
+
+	// fields of scraped stories compared with the API, and the
+	// discrepancies found (see reconcileScrapedStories)
+	reconciledFieldsTotal = newFieldCounters("reconciled_fields_total")
+	discrepanciesTotal    = newFieldCounters("discrepancies_total")
+
+	// The latest parse success rate per page type and field, exported by
+	// gauges created in setParseSuccessRate
+	parseSuccessRates   = make(map[string]float64)
+	parseSuccessRatesMu sync.Mutex
+
 	// Store histograms per route to avoid duplicate registration
 	routeHistograms = make(map[string]*metrics.Histogram)
 )
@@ -42,6 +66,33 @@ func getRouteHistogram(routeName string) *metrics.Histogram {
 	return h
 }
 
+// newFieldCounters registers a counter for every field in reconciledFields
+func newFieldCounters(name string) map[string]*metrics.Counter {
+	counters := make(map[string]*metrics.Counter, len(reconciledFields))
+	for _, field := range reconciledFields {
+		counters[field] = metrics.NewCounter(name + `{field="` + field + `"}`)
+	}
+	return counters
+}
+
+// setParseSuccessRate sets the parse_success_rate gauge of a page type and
+// field, registering it the first time
+func setParseSuccessRate(pageType, field string, rate float64) {
+	name := `parse_success_rate{pageType="` + pageType + `",field="` + field + `"}`
+
+	parseSuccessRatesMu.Lock()
+	defer parseSuccessRatesMu.Unlock()
+
+	if _, exists := parseSuccessRates[name]; !exists {
+		metrics.NewGauge(name, func() float64 {
+			parseSuccessRatesMu.Lock()
+			defer parseSuccessRatesMu.Unlock()
+			return parseSuccessRates[name]
+		})
+	}
+	parseSuccessRates[name] = rate
+}
+
 func servePrometheusMetrics() func(ctx context.Context) error {
 	mux := http.NewServeMux()
 
@@ -4,9 +4,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"sort"
 	"time"
 
+	"github.com/johnwarden/hn"
 	"github.com/pkg/errors"
 	"golang.org/x/exp/slog"
 )
@@ -31,20 +33,36 @@ var pageTypes = map[pageTypeInt]string{
 
 type ranksArray [5]int // the ranks of a story for different pageTypes
 
+// minRank returns the highest rank of the story on any page type, or
+// math.MaxInt if it isn't ranked.
+func (r ranksArray) minRank() int {
+	result := math.MaxInt
+	for _, rank := range r {
+		if rank != 0 {
+			result = min(result, rank)
+		}
+	}
+	return result
+}
+
 type dataPoint struct {
 	// One datapoint represents the state of a single story at a specific point in time.
 	// It is one row of the `dataset` table.
-	id                        int
-	score                     int
-	descendants               int
-	sampleTime                int64
-	submissionTime            int64
-	ageApprox                 int64
-	ranks                     ranksArray
-	cumulativeExpectedUpvotes float64
-	cumulativeUpvotes         int
-	flagged                   bool
-	dupe                      bool
+	id                         int
+	score                      int
+	descendants                int
+	sampleTime                 int64
+	submissionTime             int64
+	ageApprox                  int64
+	ranks                      ranksArray
+	cumulativeExpectedUpvotes  float64
+	cumulativeUpvotes          int
+	cumulativeExpectedComments float64
+	cumulativeComments         int
+	flagged                    bool
+	dupe                       bool
+	attentionModel             string  // name of the attentionModel used to compute cumulativeExpectedUpvotes
+	penalty                    float64 // domain penalty, applied in the qnRank formula with weight penaltyWeight
 }
 
 // only accumulate upvotes if we haven't gone more than 2
@@ -53,10 +71,13 @@ type dataPoint struct {
 // become less and less reasonable
 const maxElapsedTime = 120
 
-func (app app) crawlAndPostprocess(ctx context.Context) error {
+func (app app) crawlAndPostprocess(ctx context.Context) (crawlStats, error) {
 	ndb := app.ndb
 	logger := app.logger
 
+	var stats crawlStats
+	errorsBefore := crawlErrorsTotal.Get()
+
 	// Use a closure with its own local error variable, txErr.
 	err := func() (txErr error) {
 		tx, e := ndb.db.BeginTx(ctx, nil)
@@ -88,7 +109,7 @@ func (app app) crawlAndPostprocess(ctx context.Context) error {
 		}
 
 		// Perform the crawl.
-		sitewideUpvotes, err := app.crawl(ctx, tx)
+		stats, err = app.crawl(ctx, tx)
 		if err != nil {
 			return errors.Wrap(err, "crawl")
 		}
@@ -106,7 +127,7 @@ func (app app) crawlAndPostprocess(ctx context.Context) error {
 
 		// Update metrics after successful transaction.
 		submissionsTotal.Add(finalStoryCount - initialStoryCount)
-		upvotesTotal.Add(int(sitewideUpvotes))
+		upvotesTotal.Add(int(stats.SitewideUpvotes))
 
 		return nil
 	}()
@@ -121,29 +142,38 @@ func (app app) crawlAndPostprocess(ctx context.Context) error {
 		crawlErrorsTotal.Inc()
 	}
 
-	return err
+	stats.Errors = int(crawlErrorsTotal.Get() - errorsBefore)
+
+	return stats, err
 }
 
 const maxGoroutines = 50
 
-func (app app) crawl(ctx context.Context, tx *sql.Tx) (int, error) {
+func (app app) crawl(ctx context.Context, tx *sql.Tx) (crawlStats, error) {
 	ndb := app.ndb
-	client := app.hnClient
 	logger := app.logger
 
 	t := time.Now()
 	defer crawlDuration.UpdateDuration(t)
 	sampleTime := t.Unix()
+	if app.sampleTime != 0 {
+		sampleTime = app.sampleTime
+	}
+
+	if app.captureDir != "" {
+		app = app.withCapture(sampleTime)
+	}
+	client := app.rankSource
 
 	storyRanks, err := app.getRanksFromAPI(ctx)
 	if err != nil {
-		return 0, errors.Wrap(err, "getRanksFromAPI")
+		return crawlStats{}, errors.Wrap(err, "getRanksFromAPI")
 	}
 
 	// make sure we also get data for every story that was ranked on QN in the previous crawl
 	idsFromPreviousCrawl, err := app.getQNTopFromPreviousCrawl(ctx, tx)
 	if err != nil {
-		return 0, errors.Wrap(err, "getIDSFromPreviousCrawl")
+		return crawlStats{}, errors.Wrap(err, "getIDSFromPreviousCrawl")
 	}
 	for _, id := range idsFromPreviousCrawl {
 		if _, ok := storyRanks[id]; !ok {
@@ -153,14 +183,18 @@ func (app app) crawl(ctx context.Context, tx *sql.Tx) (int, error) {
 		}
 	}
 
-	stories, err := app.scrapeFrontPageStories(ctx)
+	stories, parseReport, err := app.scrapeFrontPageStories(ctx)
+	parseReport.countRanked(storyRanks)
 	if err != nil {
-		return 0, errors.Wrap(err, "scrapeFrontPageStories")
+		return crawlStats{ParseReport: parseReport}, errors.Wrap(err, "scrapeFrontPageStories")
 	}
 
 	uniqueStoryIds := getKeys(storyRanks)
 
-	// Now use the API to get details for stories we did not find on the front page
+	// items fetched from the API, stored by crawlStoryItems
+	var apiItems []hn.Item
+
+	// Now use the API to get details for stories we did not find on any of the scraped pages
 	{
 		missingStoryIDs := make([]int, 0, len(uniqueStoryIds))
 		for _, id := range uniqueStoryIds {
@@ -176,29 +210,40 @@ func (app app) crawl(ctx context.Context, tx *sql.Tx) (int, error) {
 
 		// get story details
 		logger.Info("Getting story details from API for stories that were not on the front page", "num_stories", len(uniqueStoryIds), "missing_stories", len(missingStoryIDs))
-		missingStories, err := client.GetItems(ctx, missingStoryIDs, maxGoroutines)
+		missingStories, err := app.itemCache.getItems(ctx, client, missingStoryIDs, sampleTime, logger)
 		if err != nil {
-			return 0, errors.Wrap(err, "client.GetItems")
+			return crawlStats{}, errors.Wrap(err, "client.GetItems")
 		}
 
 		if len(missingStoryIDs) != len(missingStories) {
 			panic(fmt.Sprintf("Story counts don't add up after downloading missing stories: %d, %d", len(missingStoryIDs), len(missingStories)))
 		}
 
+		apiItems = missingStories
+
 		for _, s := range missingStories {
+			// Use the same URL as the scraper for stories without a URL (e.g.
+			// Ask HN), so that the URL doesn't change when a story is no
+			// longer on the scraped pages.
+			url := s.URL
+			if url == "" {
+				url = fmt.Sprintf("https://news.ycombinator.com/item?id=%d", s.ID)
+			}
 			stories[s.ID] = ScrapedStory{
 				Story: Story{
 					ID:                     s.ID,
 					By:                     s.By,
 					Title:                  s.Title,
-					URL:                    s.URL,
+					URL:                    url,
 					SubmissionTime:         int64(s.Timestamp),
 					OriginalSubmissionTime: int64(s.Timestamp),
 					AgeApprox:              sampleTime - int64(s.Timestamp),
 					Score:                  s.Score,
 					Comments:               s.Descendants,
+					Job:                    s.Type == "job",
 				},
-				Source: "api",
+				Source:       "api",
+				FieldSources: newFieldSources("api"),
 			}
 		}
 
@@ -212,7 +257,7 @@ func (app app) crawl(ctx context.Context, tx *sql.Tx) (int, error) {
 			}
 			for id := range stories {
 				if _, ok := storyRanks[id]; !ok {
-					logger.Warn("found story on top page from scraper but not on top page from API", "story_id", id)
+					logger.Warn("found story from scraper but not in ranks from API", "story_id", id, "source", stories[id].Source)
 				}
 			}
 		}
@@ -220,14 +265,45 @@ func (app app) crawl(ctx context.Context, tx *sql.Tx) (int, error) {
 		logger.Info("Got story details from API", "nitems", len(missingStoryIDs), slog.Duration("elapsed", time.Since(t)))
 	}
 
+	// Compare the scraped stories with the API
+	reconciledItems, nDiscrepancies, err := app.reconcileScrapedStories(ctx, tx, sampleTime, stories, storyRanks)
+	if err != nil {
+		return crawlStats{}, errors.Wrap(err, "reconcileScrapedStories")
+	}
+	apiItems = append(apiItems, reconciledItems...)
+	app.itemCache.add(reconciledItems, sampleTime)
+
 	// for every story, calculate metrics used for ranking per story:
 	var sitewideUpvotes float64
 	deltaUpvotes := make([]int, len(uniqueStoryIds))          // number of upvotes (since last sample point)
 	lastCumulativeUpvotes := make([]int, len(uniqueStoryIds)) // last number of upvotes tracked by our crawler
 	lastCumulativeExpectedUpvotes := make([]float64, len(uniqueStoryIds))
+	// the same for comments
+	var sitewideComments float64
+	deltaComments := make([]int, len(uniqueStoryIds))
+	lastCumulativeComments := make([]int, len(uniqueStoryIds))
+	lastCumulativeExpectedComments := make([]float64, len(uniqueStoryIds))
 	lastSeenTimes := make([]int, len(uniqueStoryIds))
 
 	newRankChanges := make([]int, 0, 10)
+	var nRevisions int
+
+	// If the previous crawl was more than maxElapsedTime ago, interpolate
+	// over the gap.
+	var previousCrawlTime int
+	if err := tx.QueryRowContext(ctx, "select ifnull(max(sampleTime), 0) from dataset").Scan(&previousCrawlTime); err != nil {
+		return crawlStats{}, errors.Wrap(err, "selecting previous crawl time")
+	}
+	gap := int(sampleTime) - previousCrawlTime
+	var previousRanks map[int]ranksArray
+	if previousCrawlTime != 0 && gap >= maxElapsedTime && gap < maxInterpolatedGap {
+		logger.Warn("Interpolating over gap since previous crawl", "gap", gap)
+		crawlsInterpolatedTotal.Inc()
+		previousRanks, err = selectRanksAt(ctx, tx, previousCrawlTime)
+		if err != nil {
+			return crawlStats{}, errors.Wrap(err, "selectRanksAt")
+		}
+	}
 
 	logger.Info("Inserting stories into DB", "nitems", len(uniqueStoryIds))
 
@@ -245,11 +321,11 @@ STORY:
 
 		storyID := story.ID
 
-		lastSeenScore, lastSeenUpvotes, lastSeenExpectedUpvotes, lastSeenTime, err := ndb.selectLastSeenData(tx, storyID)
+		last, err := ndb.selectLastSeenData(tx, storyID)
 
 		if err != nil {
 			if !errors.Is(err, sql.ErrNoRows) {
-				return 0, errors.Wrap(err, "selectLastSeenScore")
+				return crawlStats{}, errors.Wrap(err, "selectLastSeenScore")
 			}
 
 			if story.SubmissionTime == 0 {
@@ -262,28 +338,74 @@ STORY:
 			}
 
 		} else {
-			lastCumulativeUpvotes[i] = lastSeenUpvotes
-			lastCumulativeExpectedUpvotes[i] = lastSeenExpectedUpvotes
-			lastSeenTimes[i] = lastSeenTime
-			elapsedTime := int(sampleTime) - lastSeenTime
-
-			if elapsedTime < maxElapsedTime {
-				deltaUpvotes[i] = story.Score - lastSeenScore
-				sitewideUpvotes += float64(deltaUpvotes[i]*60) / float64(elapsedTime)
+			lastCumulativeUpvotes[i] = last.cumulativeUpvotes
+			lastCumulativeExpectedUpvotes[i] = last.cumulativeExpectedUpvotes
+			lastCumulativeComments[i] = last.cumulativeComments
+			lastCumulativeExpectedComments[i] = last.cumulativeExpectedComments
+			lastSeenTimes[i] = last.sampleTime
+			elapsedTime := int(sampleTime) - last.sampleTime
+
+			if elapsedTime < maxElapsedTime || interpolateGap(elapsedTime, gap) {
+				deltaUpvotes[i] = story.Score - last.score
+				deltaComments[i] = story.Comments - last.comments
+				// Only count upvotes and comments within the default crawl
+				// depth towards sitewide upvotes and comments, so that
+				// expected upvotes don't depend on how deep we crawl.
+				// Stories with a cached score are left out, since their
+				// upvotes arrive in a lump once the item is fetched again.
+				if storyRanks[storyID].minRank() <= defaultCrawlDepth && !app.itemCache.staleScore(storyID) {
+					sitewideUpvotes += float64(deltaUpvotes[i]*60) / float64(elapsedTime)
+					sitewideComments += float64(deltaComments[i]*60) / float64(elapsedTime)
+				}
+			}
+
+			// The API doesn't tell whether a story is flagged or a dupe, so
+			// keep the status last seen on the scraped pages.
+			if story.FieldSources["Flagged"] == "api" {
+				story.Flagged = last.flagged
+			}
+			if story.FieldSources["Dupe"] == "api" {
+				story.Dupe = last.dupe
 			}
+			stories[id] = story
+
+			n, err := ndb.insertStoryRevisions(tx, sampleTime, story, last)
+			if err != nil {
+				return crawlStats{}, errors.Wrap(err, "insertStoryRevisions")
+			}
+			nRevisions += n
 		}
 
 		// save story details in database
 		_, err = ndb.insertOrReplaceStory(tx, story.Story)
 		if err != nil {
-			return 0, errors.Wrap(err, "insertOrReplaceStory")
+			return crawlStats{}, errors.Wrap(err, "insertOrReplaceStory")
 		}
 	}
 
+	nItems, err := app.crawlStoryItems(ctx, tx, sampleTime, uniqueStoryIds, apiItems)
+	if err != nil {
+		return crawlStats{}, errors.Wrap(err, "crawlStoryItems")
+	}
+
+	maxItem, nSubmissions, err := app.trackSubmissions(ctx, tx, sampleTime)
+	if err != nil {
+		return crawlStats{}, errors.Wrap(err, "trackSubmissions")
+	}
+	if err := ndb.updateSubmissionsRanked(tx, sampleTime, storyRanks); err != nil {
+		return crawlStats{}, errors.Wrap(err, "updateSubmissionsRanked")
+	}
+
 	logger.Info("Inserting rank data into DB", "nitems", len(uniqueStoryIds))
 
+	penalties, err := ndb.selectDomainPenalties(tx)
+	if err != nil {
+		return crawlStats{}, errors.Wrap(err, "selectDomainPenalties")
+	}
+
 	var sitewideDeltaExpectedUpvotes float64
 	var sitewideExpectedUpvotesShare float64
+	var nInterpolated int
 
 	if len(newRankChanges) > 0 {
 		// If there have been N new submissions, each story above rank N has occupied N+1 ranks
@@ -298,51 +420,80 @@ STORY:
 		ranks := storyRanks[id]
 		cumulativeUpvotes := lastCumulativeUpvotes[i]
 		cumulativeExpectedUpvotes := lastCumulativeExpectedUpvotes[i]
+		cumulativeComments := lastCumulativeComments[i]
+		cumulativeExpectedComments := lastCumulativeExpectedComments[i]
 		elapsedTime := int(sampleTime) - lastSeenTimes[i]
 
 		if elapsedTime < maxElapsedTime {
 
 			cumulativeUpvotes += deltaUpvotes[i]
+			cumulativeComments += deltaComments[i]
 
-		RANKS:
-			for pt, rank := range ranks {
-				pageType := pageTypeInt(pt)
-				if rank == 0 {
-					continue RANKS
-				}
-
-				exUpvoteShare := 0.0
+			exUpvoteShare := defaultAttentionModel.expectedUpvoteShareForRanks(ranks, elapsedTime, newRankChanges)
+			deltaExpectedUpvotes := exUpvoteShare * float64(sitewideUpvotes)
 
-				if pageType == new && len(newRankChanges) > 0 {
-					exUpvoteShare = expectedUpvoteShareNewPage(rank, elapsedTime, newRankChanges)
-				} else {
-					exUpvoteShare = expectedUpvoteShare(pageType, rank)
-				}
+			cumulativeExpectedUpvotes += deltaExpectedUpvotes
+			cumulativeExpectedComments += exUpvoteShare * sitewideComments
+			sitewideDeltaExpectedUpvotes += deltaExpectedUpvotes
+			sitewideExpectedUpvotesShare += exUpvoteShare
+		} else if interpolateGap(elapsedTime, gap) {
+			cumulativeUpvotes += deltaUpvotes[i]
+			cumulativeComments += deltaComments[i]
 
-				deltaExpectedUpvotes := exUpvoteShare * float64(sitewideUpvotes)
+			exUpvoteShare := defaultAttentionModel.gapUpvoteShare(previousRanks[id], ranks, elapsedTime)
+			deltaExpectedUpvotes := exUpvoteShare * float64(sitewideUpvotes)
 
-				cumulativeExpectedUpvotes += deltaExpectedUpvotes
-				sitewideDeltaExpectedUpvotes += deltaExpectedUpvotes
-				sitewideExpectedUpvotesShare += exUpvoteShare
-			}
+			cumulativeExpectedUpvotes += deltaExpectedUpvotes
+			cumulativeExpectedComments += exUpvoteShare * sitewideComments
+			sitewideDeltaExpectedUpvotes += deltaExpectedUpvotes
+			sitewideExpectedUpvotesShare += exUpvoteShare
+			nInterpolated++
 		}
 
 		datapoint := dataPoint{
-			id:                        id,
-			score:                     story.Score,
-			descendants:               story.Comments,
-			sampleTime:                sampleTime,
-			submissionTime:            story.SubmissionTime,
-			ranks:                     ranks,
-			cumulativeExpectedUpvotes: cumulativeExpectedUpvotes,
-			cumulativeUpvotes:         cumulativeUpvotes,
-			ageApprox:                 story.AgeApprox,
-			flagged:                   story.Flagged,
-			dupe:                      story.Dupe,
+			id:                         id,
+			score:                      story.Score,
+			descendants:                story.Comments,
+			sampleTime:                 sampleTime,
+			submissionTime:             story.SubmissionTime,
+			ranks:                      ranks,
+			cumulativeExpectedUpvotes:  cumulativeExpectedUpvotes,
+			cumulativeUpvotes:          cumulativeUpvotes,
+			cumulativeExpectedComments: cumulativeExpectedComments,
+			cumulativeComments:         cumulativeComments,
+			ageApprox:                  story.AgeApprox,
+			flagged:                    story.Flagged,
+			dupe:                       story.Dupe,
+			attentionModel:             defaultAttentionModel.Name,
+			penalty:                    penalties.penalty(story.Story),
 		}
 
 		if err := ndb.insertDataPoint(tx, datapoint); err != nil {
-			return int(sitewideUpvotes), errors.Wrap(err, "insertDataPoint")
+			return crawlStats{}, errors.Wrap(err, "insertDataPoint")
+		}
+	}
+
+	stats := crawlStats{
+		SitewideUpvotes:              sitewideUpvotes,
+		SitewideDeltaExpectedUpvotes: sitewideDeltaExpectedUpvotes,
+		SitewideExpectedUpvotesShare: sitewideExpectedUpvotesShare,
+		SitewideComments:             sitewideComments,
+		Interpolated:                 nInterpolated,
+		AttentionModel:               defaultAttentionModel.Name,
+		ParseReport:                  parseReport,
+		MaxItem:                      maxItem,
+		Submissions:                  nSubmissions,
+	}
+	for _, id := range uniqueStoryIds {
+		story, ok := stories[id]
+		if !ok || story.ID == 0 {
+			continue
+		}
+		stats.Stories++
+		if story.Source == "api" {
+			stats.FromAPI++
+		} else {
+			stats.Scraped++
 		}
 	}
 
@@ -351,9 +502,17 @@ STORY:
 		"deltaExpectedUpvotes", sitewideDeltaExpectedUpvotes,
 		"sitewideUpvotes", sitewideUpvotes,
 		"sitewideExpectedUpvotesShare", sitewideExpectedUpvotesShare,
-		"dataPoints", len(stories))
-
-	return int(sitewideUpvotes), nil
+		"sitewideComments", sitewideComments,
+		"dataPoints", len(stories),
+		"scraped", stats.Scraped,
+		"fromAPI", stats.FromAPI,
+		"interpolated", nInterpolated,
+		"revisions", nRevisions,
+		"discrepancies", nDiscrepancies,
+		"storyItems", nItems,
+		"submissions", nSubmissions)
+
+	return stats, nil
 }
 
 // getRanksFromAPI gets all ranks for all page types from the API and puts them into
@@ -365,7 +524,7 @@ func (app app) getRanksFromAPI(ctx context.Context) (map[int]ranksArray, error)
 
 	storyRanks := map[int]ranksArray{}
 
-	client := app.hnClient
+	client := app.rankSource
 
 	for pageType := top; pageType <= show; pageType++ {
 		pageTypeName := pageTypes[pageType]
@@ -390,8 +549,8 @@ func (app app) getRanksFromAPI(ctx context.Context) (map[int]ranksArray, error)
 			ranks[pageType] = zeroBasedRank + 1
 			storyRanks[ID] = ranks
 
-			// only take stories which appear on the first 90 ranks
-			if zeroBasedRank+1 >= 90 {
+			// only take stories within the crawl depth
+			if zeroBasedRank+1 >= app.crawlDepths.ranks(pageType) {
 				break
 			}
 
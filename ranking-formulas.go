@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// A rankingFormula is a named, experimental ranking. The ranks of every
+// ranking formula are computed on each crawl by crawlPostprocess and stored
+// in the formula_ranks table, and served at /r/<name>. This way several
+// experiments can run concurrently, each with its own parameters.
+//
+// Ranking formulas are loaded from the JSON file in RANKING_FORMULAS_FILE,
+// for example:
+//
+//	[{
+//	  "name": "gravity-1.6",
+//	  "description": "the upvoterate ranking with more gravity",
+//	  "gravity": 1.6
+//	}]
+//
+// orderBy is an SQL order by expression over the columns of
+// sql/formula-ranks.sql, and defaults to the qnRank formula. Parameters
+// that are not given take their default values.
+type rankingFormula struct {
+	Name        string
+	Description string
+	OrderBy     string
+	FrontPageParams
+}
+
+var formulaNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+func (f *rankingFormula) UnmarshalJSON(b []byte) error {
+	// Use a type without the UnmarshalJSON method to avoid infinite recursion
+	type plainRankingFormula rankingFormula
+
+	p := plainRankingFormula{
+		OrderBy:         qnRankFormulaSQL,
+		FrontPageParams: defaultFrontPageParams,
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return err
+	}
+
+	*f = rankingFormula(p)
+	return nil
+}
+
+// ranking is the name of the ranking served by getFrontPageStories.
+func (f rankingFormula) ranking() string {
+	return "r/" + f.Name
+}
+
+// formulaName returns the name of the ranking formula if ranking is the
+// ranking of a rankingFormula.
+func formulaName(ranking string) (string, bool) {
+	return strings.CutPrefix(ranking, "r/")
+}
+
+func loadRankingFormulas(filename string) ([]rankingFormula, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", filename)
+	}
+
+	var formulas []rankingFormula
+	if err = json.Unmarshal(b, &formulas); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", filename)
+	}
+
+	names := make(map[string]bool, len(formulas))
+	for _, f := range formulas {
+		if !formulaNameRegexp.MatchString(f.Name) {
+			return nil, fmt.Errorf("%s: invalid ranking formula name %q. Use lowercase letters, digits and dashes", filename, f.Name)
+		}
+		if names[f.Name] {
+			return nil, fmt.Errorf("%s: duplicate ranking formula %s", filename, f.Name)
+		}
+		names[f.Name] = true
+	}
+
+	return formulas, nil
+}
+
+var formulaRanksSQL = readSQLSource("formula-ranks.sql")
+
+func (f rankingFormula) ranksSQL() string {
+	p := f.FrontPageParams
+	return fmt.Sprintf(formulaRanksSQL, p.PriorWeight, p.OverallPriorWeight, p.Gravity, p.PenaltyWeight, p.FatigueFactor, f.OrderBy)
+}
+
+// validateRankingFormulas checks that the SQL of every ranking formula is
+// valid, so errors in RANKING_FORMULAS_FILE are caught on startup instead of
+// failing every crawl.
+func (ndb newsDatabase) validateRankingFormulas(formulas []rankingFormula) error {
+	for _, f := range formulas {
+		stmt, err := ndb.db.Prepare(f.ranksSQL())
+		if err != nil {
+			return errors.Wrapf(err, "ranking formula %s", f.Name)
+		}
+		stmt.Close()
+	}
+	return nil
+}
+
+func (app app) updateFormulaRanks(ctx context.Context, tx *sql.Tx) error {
+	t := time.Now()
+
+	for _, f := range app.rankingFormulas {
+		if _, err := tx.ExecContext(ctx, f.ranksSQL(), f.Name); err != nil {
+			return errors.Wrapf(err, "ranking formula %s", f.Name)
+		}
+	}
+
+	app.logger.Info("Finished executing updateFormulaRanks", "formulas", len(app.rankingFormulas), slog.Duration("elapsed", time.Since(t)))
+
+	return nil
+}
+
+// formulaPageHandler serves the front page for a rankingFormula. The
+// ranks are precomputed with the formula's parameters, so only the pastTime
+// URL parameter is used. Upvote rates are shown with the default parameters,
+// as on all other pages.
+func (app app) formulaPageHandler(f rankingFormula) func(http.ResponseWriter, *http.Request, OptionalFrontPageParams) error {
+	return func(w http.ResponseWriter, r *http.Request, params OptionalFrontPageParams) error {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		p := defaultFrontPageParams
+		if params.PastTime.Valid {
+			p.PastTime = params.PastTime.Int64
+		}
+
+		err := app.serveFrontPage(r, w, f.ranking(), p)
+		return errors.Wrap(err, "serveFrontPage")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadRankingFormulas(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    []rankingFormula
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			json: `[{"name": "default"}]`,
+			want: []rankingFormula{{Name: "default", OrderBy: qnRankFormulaSQL, FrontPageParams: defaultFrontPageParams}},
+		},
+		{
+			name: "parameters",
+			json: `[{"name": "gravity-1-6", "description": "more gravity", "gravity": 1.6, "orderBy": "score desc"}]`,
+			want: []rankingFormula{{
+				Name:        "gravity-1-6",
+				Description: "more gravity",
+				OrderBy:     "score desc",
+				FrontPageParams: FrontPageParams{
+					ModelParams:        defaultFrontPageParams.ModelParams,
+					OverallPriorWeight: defaultFrontPageParams.OverallPriorWeight,
+					Gravity:            1.6,
+					PenaltyWeight:      defaultFrontPageParams.PenaltyWeight,
+				},
+			}},
+		},
+		{name: "invalid name", json: `[{"name": "Gravity 1.6"}]`, wantErr: true},
+		{name: "no name", json: `[{"gravity": 1.6}]`, wantErr: true},
+		{name: "duplicate name", json: `[{"name": "a"}, {"name": "a", "gravity": 2}]`, wantErr: true},
+		{name: "not a list", json: `{"name": "a"}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		filename := filepath.Join(t.TempDir(), "formulas.json")
+		if err := os.WriteFile(filename, []byte(tt.json), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		formulas, err := loadRankingFormulas(filename)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: loadRankingFormulas returned no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: loadRankingFormulas returned error: %v", tt.name, err)
+			continue
+		}
+		if len(formulas) != len(tt.want) {
+			t.Errorf("%s: loaded %d formulas, want %d", tt.name, len(formulas), len(tt.want))
+			continue
+		}
+		for i := range formulas {
+			if formulas[i] != tt.want[i] {
+				t.Errorf("%s: formula %d = %+v, want %+v", tt.name, i, formulas[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestFormulaName(t *testing.T) {
+	tests := []struct {
+		ranking string
+		want    string
+		wantOK  bool
+	}{
+		{ranking: "r/gravity-1-6", want: "gravity-1-6", wantOK: true},
+		{ranking: "hntop", want: "hntop", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		got, ok := formulaName(tt.ranking)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("formulaName(%q) = %q, %v, want %q, %v", tt.ranking, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
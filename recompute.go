@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// recomputeState is what the recompute command remembers about a story
+// between crawls. It plays the role of selectLastSeenData in crawl.
+type recomputeState struct {
+	score                     int
+	sampleTime                int
+	ranks                     ranksArray
+	cumulativeUpvotes         int
+	cumulativeExpectedUpvotes float64
+}
+
+var tableNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// number of crawls to recompute in a single transaction
+const recomputeBatchSize = 100
+
+// runRecomputeCommand recomputes cumulativeUpvotes and
+// cumulativeExpectedUpvotes for the whole dataset using a given attention
+// model, writing the results into a separate table so they can be compared
+// with the values computed at crawl time.
+//
+// The dataset is walked crawl by crawl in sampleTime order. Sitewide upvotes
+// are reconstructed from the changes in score of every story between crawls,
+// and the times of new-page rank changes from the first appearance of each
+// new story, exactly as in crawl. Stories whose early datapoints have already
+// been deleted start from zero.
+func runRecomputeCommand(ctx context.Context, logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("recompute", flag.ExitOnError)
+	dataDir := flags.String("data-dir", os.Getenv("SQLITE_DATA_DIR"), "directory containing the database")
+	modelsFile := flags.String("models", os.Getenv("ATTENTION_MODEL_FILE"), "JSON file with attention models to register")
+	modelName := flags.String("model", os.Getenv("ATTENTION_MODEL"), "name of the attention model to use (default: the builtin model)")
+	table := flags.String("table", "recomputed_dataset", "table to write the results into. It is replaced if it exists")
+	_ = flags.Parse(args)
+
+	if *dataDir == "" {
+		flags.Usage()
+		return errors.New("-data-dir or SQLITE_DATA_DIR is required")
+	}
+
+	if !tableNameRegexp.MatchString(*table) {
+		return fmt.Errorf("invalid table name %q", *table)
+	}
+
+	ndb, err := openNewsDatabase(*dataDir, logger)
+	if err != nil {
+		return errors.Wrap(err, "openNewsDatabase")
+	}
+	defer ndb.close()
+
+	model, err := ndb.initAttentionModel(*modelsFile, *modelName)
+	if err != nil {
+		return errors.Wrap(err, "initAttentionModel")
+	}
+
+	for _, s := range []string{
+		fmt.Sprintf("drop table if exists %s", *table),
+		fmt.Sprintf(`
+			create table %s (
+				id integer not null
+				, sampleTime integer not null
+				, cumulativeUpvotes integer not null
+				, cumulativeExpectedUpvotes real not null
+				, attentionModel text not null
+				, primary key(id, sampleTime)
+			)
+		`, *table),
+	} {
+		if _, err := ndb.db.ExecContext(ctx, s); err != nil {
+			return errors.Wrapf(err, "creating table %s", *table)
+		}
+	}
+
+	sampleTimes, err := ndb.selectSampleTimes(ctx)
+	if err != nil {
+		return errors.Wrap(err, "selectSampleTimes")
+	}
+
+	logger.Info("Recomputing expected upvotes", "crawls", len(sampleTimes), "table", *table, "attentionModel", model.Name)
+
+	states := make(map[int]recomputeState)
+
+	for start := 0; start < len(sampleTimes); start += recomputeBatchSize {
+		end := start + recomputeBatchSize
+		if end > len(sampleTimes) {
+			end = len(sampleTimes)
+		}
+
+		err := func() (txErr error) {
+			tx, err := ndb.db.BeginTx(ctx, nil)
+			if err != nil {
+				return errors.Wrap(err, "BeginTx")
+			}
+			defer func() {
+				if txErr != nil {
+					_ = tx.Rollback()
+					return
+				}
+				txErr = errors.Wrap(tx.Commit(), "tx.Commit")
+			}()
+
+			for i, sampleTime := range sampleTimes[start:end] {
+				var previousSampleTime int
+				if start+i > 0 {
+					previousSampleTime = sampleTimes[start+i-1]
+				}
+				if err := recomputeCrawl(ctx, tx, model, *table, sampleTime, previousSampleTime, states); err != nil {
+					return errors.Wrapf(err, "recomputing crawl at %d", sampleTime)
+				}
+			}
+
+			return nil
+		}()
+		if err != nil {
+			return err
+		}
+
+		logger.Info("Recomputed crawls", "done", end, "total", len(sampleTimes))
+	}
+
+	return nil
+}
+
+// recomputeCrawl recomputes the datapoints of a single crawl and updates
+// states for the next one. Gaps since the previous crawl are interpolated as
+// in crawl.
+func recomputeCrawl(ctx context.Context, tx *sql.Tx, model attentionModel, table string, sampleTime, previousSampleTime int, states map[int]recomputeState) error {
+	type row struct {
+		id             int
+		score          int
+		submissionTime int
+		ranks          ranksArray
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		select id, score, submissionTime
+			, ifnull(topRank, 0), ifnull(newRank, 0), ifnull(bestRank, 0), ifnull(askRank, 0), ifnull(showRank, 0)
+		from dataset
+		where sampleTime = ?
+	`, sampleTime)
+	if err != nil {
+		return errors.Wrap(err, "selecting datapoints")
+	}
+
+	var crawlRows []row
+	for rows.Next() {
+		var r row
+		err := rows.Scan(&r.id, &r.score, &r.submissionTime, &r.ranks[0], &r.ranks[1], &r.ranks[2], &r.ranks[3], &r.ranks[4])
+		if err != nil {
+			rows.Close()
+			return errors.Wrap(err, "rows.Scan")
+		}
+		crawlRows = append(crawlRows, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "selecting datapoints")
+	}
+
+	gap := sampleTime - previousSampleTime
+	if previousSampleTime == 0 {
+		gap = 0
+	}
+
+	var sitewideUpvotes float64
+	newRankChanges := make([]int, 0, 10)
+	lastSeenTimes := make([]int, len(crawlRows))
+
+	for i, r := range crawlRows {
+		last, ok := states[r.id]
+		if !ok {
+			if r.ranks[new] != 0 {
+				newRankChanges = append(newRankChanges, sampleTime-r.submissionTime)
+				lastSeenTimes[i] = r.submissionTime
+			}
+			continue
+		}
+
+		lastSeenTimes[i] = last.sampleTime
+		elapsedTime := sampleTime - last.sampleTime
+		if (elapsedTime < maxElapsedTime || interpolateGap(elapsedTime, gap)) && r.ranks.minRank() <= defaultCrawlDepth {
+			sitewideUpvotes += float64((r.score-last.score)*60) / float64(elapsedTime)
+		}
+	}
+
+	sort.Ints(newRankChanges)
+
+	stmt, err := tx.PrepareContext(ctx, fmt.Sprintf(
+		"insert into %s(id, sampleTime, cumulativeUpvotes, cumulativeExpectedUpvotes, attentionModel) values (?, ?, ?, ?, ?)", table))
+	if err != nil {
+		return errors.Wrap(err, "preparing insert")
+	}
+	defer stmt.Close()
+
+	for i, r := range crawlRows {
+		s := states[r.id]
+
+		elapsedTime := sampleTime - lastSeenTimes[i]
+		if elapsedTime < maxElapsedTime {
+			if s.sampleTime != 0 {
+				s.cumulativeUpvotes += r.score - s.score
+			}
+			s.cumulativeExpectedUpvotes += model.expectedUpvoteShareForRanks(r.ranks, elapsedTime, newRankChanges) * sitewideUpvotes
+		} else if interpolateGap(elapsedTime, gap) {
+			s.cumulativeUpvotes += r.score - s.score
+			s.cumulativeExpectedUpvotes += model.gapUpvoteShare(s.ranks, r.ranks, elapsedTime) * sitewideUpvotes
+		}
+
+		s.score = r.score
+		s.sampleTime = sampleTime
+		s.ranks = r.ranks
+		states[r.id] = s
+
+		if _, err := stmt.ExecContext(ctx, r.id, sampleTime, s.cumulativeUpvotes, s.cumulativeExpectedUpvotes, model.Name); err != nil {
+			return errors.Wrap(err, "inserting datapoint")
+		}
+	}
+
+	return nil
+}
+
+func (ndb newsDatabase) selectSampleTimes(ctx context.Context) ([]int, error) {
+	rows, err := ndb.db.QueryContext(ctx, "select distinct sampleTime from dataset order by sampleTime")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sampleTimes []int
+	for rows.Next() {
+		var sampleTime int
+		if err := rows.Scan(&sampleTime); err != nil {
+			return nil, err
+		}
+		sampleTimes = append(sampleTimes, sampleTime)
+	}
+
+	return sampleTimes, rows.Err()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/johnwarden/hn"
+	"github.com/pkg/errors"
+)
+
+// Reconciliation compares the details of every scraped story with the
+// API. The API lags behind the live HTML, so scores often differ by a few
+// upvotes, and ranks by a few positions when stories move between the two
+// requests. Every difference is recorded in the discrepancies table, with
+// the delta (API minus scraped) for numeric fields, so that the lag can be
+// quantified. Discrepancies are kept for a week, like parse failures.
+
+// reconciledFields are the fields compared by reconcileStory
+var reconciledFields = []string{"score", "title", "rank"}
+
+const discrepanciesRetention = 7 * 24 * 60 * 60
+
+// A discrepancy is a difference between the details of a story on the page
+// it was scraped from and in the API.
+type discrepancy struct {
+	StoryID  int
+	PageType string
+	Field    string
+	Scraped  string
+	API      string
+	// API minus scraped, for score and rank
+	Delta sql.NullFloat64
+}
+
+func numericDiscrepancy(story ScrapedStory, field string, scraped, api int) discrepancy {
+	return discrepancy{
+		StoryID:  story.ID,
+		PageType: story.Source,
+		Field:    field,
+		Scraped:  strconv.Itoa(scraped),
+		API:      strconv.Itoa(api),
+		Delta:    sql.NullFloat64{Float64: float64(api - scraped), Valid: true},
+	}
+}
+
+// reconcileStory compares a scraped story with its API item and ranks, and
+// returns the fields that were compared and the discrepancies found. Jobs
+// have no score on the page, and the rank is only compared if the API
+// ranks the story on the page it was scraped from.
+func reconcileStory(story ScrapedStory, item hn.Item, ranks ranksArray) ([]string, []discrepancy) {
+	var checked []string
+	var discrepancies []discrepancy
+
+	if !story.Job {
+		checked = append(checked, "score")
+		if item.Score != story.Score {
+			discrepancies = append(discrepancies, numericDiscrepancy(story, "score", story.Score, item.Score))
+		}
+	}
+
+	checked = append(checked, "title")
+	if item.Title != story.Title {
+		discrepancies = append(discrepancies, discrepancy{
+			StoryID:  story.ID,
+			PageType: story.Source,
+			Field:    "title",
+			Scraped:  story.Title,
+			API:      item.Title,
+		})
+	}
+
+	if pageType, ok := pageTypeByName(story.Source); ok && ranks[pageType] != 0 {
+		checked = append(checked, "rank")
+		if ranks[pageType] != story.Rank {
+			discrepancies = append(discrepancies, numericDiscrepancy(story, "rank", story.Rank, ranks[pageType]))
+		}
+	}
+
+	return checked, discrepancies
+}
+
+// reconcileScrapedStories fetches the API items of the scraped stories that
+// the API also ranks, compares them with reconcileStory, and stores the
+// discrepancies, deleting those older than discrepanciesRetention. It
+// returns the items fetched, for crawlStoryItems, and the number of
+// discrepancies. Failures to fetch items are logged but don't fail the
+// crawl.
+func (app app) reconcileScrapedStories(ctx context.Context, tx *sql.Tx, sampleTime int64, stories map[int]ScrapedStory, storyRanks map[int]ranksArray) ([]hn.Item, int, error) {
+	ids := make([]int, 0, len(stories))
+	for id, story := range stories {
+		if _, ok := storyRanks[id]; ok && story.Source != "api" {
+			ids = append(ids, id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	items, err := app.rankSource.GetItems(ctx, ids, maxGoroutines)
+	if err != nil {
+		LogErrorf(app.logger, "Failed to fetch items of scraped stories: %v", err)
+		crawlErrorsTotal.Inc()
+	}
+
+	var fetched []hn.Item
+	var n int
+	for _, item := range items {
+		// items that failed to download are left empty
+		if item.ID == 0 {
+			continue
+		}
+		fetched = append(fetched, item)
+
+		checked, discrepancies := reconcileStory(stories[item.ID], item, storyRanks[item.ID])
+		for _, field := range checked {
+			reconciledFieldsTotal[field].Inc()
+		}
+		for _, d := range discrepancies {
+			discrepanciesTotal[d.Field].Inc()
+			if err := app.ndb.insertDiscrepancy(tx, sampleTime, d); err != nil {
+				return fetched, n, errors.Wrap(err, "insertDiscrepancy")
+			}
+			n++
+		}
+	}
+
+	if _, err := tx.Exec(`delete from discrepancies where sampleTime < ?`, sampleTime-discrepanciesRetention); err != nil {
+		return fetched, n, errors.Wrap(err, "deleting old discrepancies")
+	}
+
+	return fetched, n, nil
+}
+
+func (ndb newsDatabase) insertDiscrepancy(tx *sql.Tx, sampleTime int64, d discrepancy) error {
+	_, err := tx.Exec(`
+		insert into discrepancies (sampleTime, id, pageType, field, scraped, api, delta) values (?, ?, ?, ?, ?, ?, ?)
+		on conflict do nothing
+	`, sampleTime, d.StoryID, d.PageType, d.Field, d.Scraped, d.API, d.Delta)
+	return err
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+
+	"github.com/johnwarden/hn"
+)
+
+func TestReconcileStory(t *testing.T) {
+	scraped := func(source string, score, rank int, job bool) ScrapedStory {
+		return ScrapedStory{
+			Story:  Story{ID: 1, Title: "Title", Score: score, Job: job},
+			Rank:   rank,
+			Source: source,
+		}
+	}
+	delta := func(d float64) sql.NullFloat64 {
+		return sql.NullFloat64{Float64: d, Valid: true}
+	}
+
+	tests := []struct {
+		name          string
+		story         ScrapedStory
+		item          hn.Item
+		ranks         ranksArray
+		checked       []string
+		discrepancies []discrepancy
+	}{
+		{
+			name:    "no discrepancies",
+			story:   scraped("top", 10, 3, false),
+			item:    hn.Item{ID: 1, Title: "Title", Score: 10},
+			ranks:   ranksArray{3},
+			checked: []string{"score", "title", "rank"},
+		},
+		{
+			name:    "API lags behind",
+			story:   scraped("top", 12, 3, false),
+			item:    hn.Item{ID: 1, Title: "Title", Score: 10},
+			ranks:   ranksArray{4},
+			checked: []string{"score", "title", "rank"},
+			discrepancies: []discrepancy{
+				{StoryID: 1, PageType: "top", Field: "score", Scraped: "12", API: "10", Delta: delta(-2)},
+				{StoryID: 1, PageType: "top", Field: "rank", Scraped: "3", API: "4", Delta: delta(1)},
+			},
+		},
+		{
+			name:    "edited title",
+			story:   scraped("new", 10, 3, false),
+			item:    hn.Item{ID: 1, Title: "Old title", Score: 10},
+			ranks:   ranksArray{0, 3},
+			checked: []string{"score", "title", "rank"},
+			discrepancies: []discrepancy{
+				{StoryID: 1, PageType: "new", Field: "title", Scraped: "Title", API: "Old title"},
+			},
+		},
+		{
+			name:    "jobs have no score",
+			story:   scraped("top", 0, 3, true),
+			item:    hn.Item{ID: 1, Title: "Title", Score: 1},
+			ranks:   ranksArray{3},
+			checked: []string{"title", "rank"},
+		},
+		{
+			name:    "not ranked by the API on the scraped page",
+			story:   scraped("best", 10, 3, false),
+			item:    hn.Item{ID: 1, Title: "Title", Score: 10},
+			ranks:   ranksArray{3},
+			checked: []string{"score", "title"},
+		},
+	}
+
+	for _, tt := range tests {
+		checked, discrepancies := reconcileStory(tt.story, tt.item, tt.ranks)
+		if !reflect.DeepEqual(checked, tt.checked) {
+			t.Errorf("%s: checked %v, want %v", tt.name, checked, tt.checked)
+		}
+		if !reflect.DeepEqual(discrepancies, tt.discrepancies) {
+			t.Errorf("%s: discrepancies %+v, want %+v", tt.name, discrepancies, tt.discrepancies)
+		}
+	}
+}
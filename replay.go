@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// runReplayCommand feeds crawls recorded with CAPTURE_DIR through crawl and
+// crawlPostprocess again, in order, writing the results into a new database.
+// This is useful for reproducing scraper errors, and for re-deriving the
+// dataset after fixing bugs in the postprocessing SQL.
+func runReplayCommand(ctx context.Context, logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	capturesDir := flags.String("captures", os.Getenv("CAPTURE_DIR"), "directory containing captured crawls")
+	dataDir := flags.String("data-dir", "", "directory to create the new database in")
+	_ = flags.Parse(args)
+
+	if *capturesDir == "" || *dataDir == "" {
+		flags.Usage()
+		return errors.New("both -captures and -data-dir are required")
+	}
+
+	if _, err := os.Stat(filepath.Join(*dataDir, sqliteDataFilename)); err == nil {
+		return fmt.Errorf("%s already contains a database. Replay needs a fresh data directory", *dataDir)
+	}
+
+	sampleTimes, err := listCaptures(*capturesDir)
+	if err != nil {
+		return errors.Wrap(err, "listCaptures")
+	}
+
+	ndb, err := openNewsDatabase(*dataDir, logger)
+	if err != nil {
+		return errors.Wrap(err, "openNewsDatabase")
+	}
+	defer ndb.close()
+
+	model, err := ndb.initAttentionModel(os.Getenv("ATTENTION_MODEL_FILE"), os.Getenv("ATTENTION_MODEL"))
+	if err != nil {
+		return errors.Wrap(err, "initAttentionModel")
+	}
+	setAttentionModel(model)
+
+	crawlDepths, err := parseCrawlDepths(os.Getenv("CRAWL_DEPTH"))
+	if err != nil {
+		return errors.Wrap(err, "CRAWL_DEPTH")
+	}
+
+	parseHealthThreshold, err := parseParseHealthThreshold(os.Getenv("PARSE_HEALTH_THRESHOLD"))
+	if err != nil {
+		return errors.Wrap(err, "PARSE_HEALTH_THRESHOLD")
+	}
+
+	logger.Info("Replaying captured crawls", "captures", len(sampleTimes), "dataDir", *dataDir, "attentionModel", model.Name)
+
+	var nFailed int
+	for _, sampleTime := range sampleTimes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		fixtures := fixtureSource{dir: filepath.Join(*capturesDir, strconv.FormatInt(sampleTime, 10))}
+
+		replayApp := app{
+			ndb:          ndb,
+			logger:       logger,
+			rankSource:   fixtures,
+			storyScraper: fixtures,
+			sampleTime:   sampleTime,
+			crawlDepths:  crawlDepths,
+
+			parseHealthThreshold: parseHealthThreshold,
+		}
+
+		// Keep going after errors: a failing capture is usually what we are
+		// trying to reproduce.
+		if err := replayApp.crawlAndRecord(ctx, sampleTime, 0); err != nil {
+			logger.Error("Failed to replay crawl", err, "sampleTime", sampleTime)
+			nFailed++
+		}
+	}
+
+	logger.Info("Finished replay", "crawls", len(sampleTimes), "failed", nFailed)
+
+	return nil
+}
+
+// listCaptures returns the sampleTimes of all crawls captured in dir, in
+// chronological order.
+func listCaptures(dir string) ([]int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", dir)
+	}
+
+	sampleTimes := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		sampleTime, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			// not a capture directory
+			continue
+		}
+		sampleTimes = append(sampleTimes, sampleTime)
+	}
+
+	sort.Slice(sampleTimes, func(i, j int) bool { return sampleTimes[i] < sampleTimes[j] })
+
+	return sampleTimes, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+// A Resubmission is a story being resubmitted from the second-chance pool
+// (or otherwise re-upped by moderators). HN doesn't publish resubmission
+// times, so as in resubmissions.sql they are estimated from the approximate
+// age shown on the front page ("3 hours ago"). Each crawl narrows the window
+// [LowerBound, UpperBound] in which the story was resubmitted.
+//
+// Confidence is 1 if the resubmission time is known exactly, and goes down
+// to 0 for a window of two hours or more.
+type Resubmission struct {
+	StoryID                int
+	Title                  string
+	OriginalSubmissionTime int64
+	LowerBound             int64
+	UpperBound             int64
+	Confidence             float64
+	FirstSeen              int64
+	LastSeen               int64
+}
+
+const (
+	// the approximate age can be off by a couple of minutes either way
+	resubmissionAgeSlack = 100
+	// a resubmission window this far after the previous one is a new
+	// resubmission of the same story
+	resubmissionGap = 3600
+)
+
+func (r Resubmission) LowerBoundISOString() string {
+	return time.Unix(r.LowerBound, 0).UTC().Format("2006-01-02T15:04")
+}
+
+func (r Resubmission) UpperBoundISOString() string {
+	return time.Unix(r.UpperBound, 0).UTC().Format("2006-01-02T15:04")
+}
+
+func (r Resubmission) OriginalSubmissionTimeISOString() string {
+	return time.Unix(r.OriginalSubmissionTime, 0).UTC().Format("2006-01-02T15:04")
+}
+
+func (r Resubmission) ConfidenceString() string {
+	return fmt.Sprintf("%.0f%%", r.Confidence*100)
+}
+
+func resubmissionConfidence(lowerBound, upperBound int64) float64 {
+	return math.Max(0, 1-float64(upperBound-lowerBound)/7200)
+}
+
+// resubmissionWindow returns bounds on the resubmission time of a story that
+// was shown as ageApprox seconds old at sampleTime. Ages are rounded down to
+// the minute, or to the hour for stories at least an hour old.
+func resubmissionWindow(sampleTime, ageApprox int64) (int64, int64) {
+	var rounding int64 = 59
+	if ageApprox >= 3600 {
+		rounding = 59 * 60
+	}
+	return sampleTime - ageApprox - rounding - resubmissionAgeSlack, sampleTime - ageApprox + resubmissionAgeSlack
+}
+
+// updateResubmissions records the resubmissions of stories in the latest
+// crawl, using the same criteria as resubmissions.sql. If the story's
+// previous resubmission window overlaps the new one, the window is narrowed
+// down, otherwise a new resubmission is recorded.
+func (app app) updateResubmissions(ctx context.Context, tx *sql.Tx) error {
+	t := time.Now()
+
+	rows, err := tx.QueryContext(ctx, `
+		select
+			d.id
+			, d.sampleTime
+			, d.ageApprox
+			, s.timestamp
+			, r.lowerBound
+			, r.upperBound
+			, r.firstSeen
+		from dataset d join stories s on s.id = d.id
+		left join resubmissions r on r.id = d.id and r.firstSeen = (
+			select max(firstSeen) from resubmissions where id = d.id
+		)
+		where d.sampleTime = (select max(sampleTime) from dataset)
+		and d.sampleTime - d.ageApprox - s.timestamp > 3600*2
+		and d.ageApprox < 3600*24
+		and not s.job
+	`)
+	if err != nil {
+		return errors.Wrap(err, "selecting resubmitted stories")
+	}
+
+	var resubmissions []Resubmission
+	var previous []sql.NullInt64
+	for rows.Next() {
+		var r Resubmission
+		var ageApprox int64
+		var lowerBound, upperBound, firstSeen sql.NullInt64
+		if err := rows.Scan(&r.StoryID, &r.LastSeen, &ageApprox, &r.OriginalSubmissionTime, &lowerBound, &upperBound, &firstSeen); err != nil {
+			rows.Close()
+			return errors.Wrap(err, "rows.Scan")
+		}
+
+		r.FirstSeen = r.LastSeen
+		r.LowerBound, r.UpperBound = resubmissionWindow(r.LastSeen, ageApprox)
+
+		if firstSeen.Valid && r.LowerBound <= upperBound.Int64+resubmissionGap {
+			// the same resubmission
+			r.FirstSeen = firstSeen.Int64
+			lower := max(r.LowerBound, lowerBound.Int64)
+			upper := min(r.UpperBound, upperBound.Int64)
+			// The windows should overlap, unless the age was off by more than
+			// resubmissionAgeSlack. Then use the latest window.
+			if lower <= upper {
+				r.LowerBound, r.UpperBound = lower, upper
+			}
+		}
+		r.Confidence = resubmissionConfidence(r.LowerBound, r.UpperBound)
+
+		resubmissions = append(resubmissions, r)
+		previous = append(previous, firstSeen)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "selecting resubmitted stories")
+	}
+
+	var detected int
+	for i, r := range resubmissions {
+		if !previous[i].Valid || previous[i].Int64 != r.FirstSeen {
+			detected++
+		}
+
+		_, err := tx.ExecContext(ctx, `
+			insert into resubmissions (id, originalSubmissionTime, lowerBound, upperBound, confidence, firstSeen, lastSeen)
+			values (?, ?, ?, ?, ?, ?, ?)
+			on conflict (id, firstSeen) do update set
+				lowerBound = excluded.lowerBound
+				, upperBound = excluded.upperBound
+				, confidence = excluded.confidence
+				, lastSeen = excluded.lastSeen
+		`, r.StoryID, r.OriginalSubmissionTime, r.LowerBound, r.UpperBound, r.Confidence, r.FirstSeen, r.LastSeen)
+		if err != nil {
+			return errors.Wrap(err, "upserting resubmission")
+		}
+	}
+
+	app.logger.Info("Finished executing updateResubmissions", "resubmitted", len(resubmissions), "new", detected, slog.Duration("elapsed", time.Since(t)))
+
+	return nil
+}
+
+const resubmissionsHistoryLimit = 200
+
+// selectResubmissions returns the latest resubmissions of a story, or of all
+// stories if storyID is 0.
+func (ndb newsDatabase) selectResubmissions(ctx context.Context, storyID int) ([]Resubmission, error) {
+	query := `
+		select id, title, originalSubmissionTime, lowerBound, upperBound, confidence, firstSeen, lastSeen
+		from resubmissions join stories using (id)
+		%s
+		order by lowerBound desc
+		limit %d
+	`
+	var rows *sql.Rows
+	var err error
+	if storyID == 0 {
+		rows, err = ndb.db.QueryContext(ctx, fmt.Sprintf(query, "", resubmissionsHistoryLimit))
+	} else {
+		rows, err = ndb.db.QueryContext(ctx, fmt.Sprintf(query, "where id = ?", resubmissionsHistoryLimit), storyID)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting resubmissions")
+	}
+	defer rows.Close()
+
+	var resubmissions []Resubmission
+	for rows.Next() {
+		var r Resubmission
+		if err := rows.Scan(&r.StoryID, &r.Title, &r.OriginalSubmissionTime, &r.LowerBound, &r.UpperBound, &r.Confidence, &r.FirstSeen, &r.LastSeen); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		resubmissions = append(resubmissions, r)
+	}
+
+	return resubmissions, rows.Err()
+}
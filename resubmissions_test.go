@@ -0,0 +1,64 @@
+package main
+
+import "testing"
+
+func TestResubmissionWindow(t *testing.T) {
+	const sampleTime = 1_700_000_000
+
+	tests := []struct {
+		name      string
+		ageApprox int64
+		lower     int64
+		upper     int64
+	}{
+		{
+			name:      "just submitted",
+			ageApprox: 0,
+			lower:     sampleTime - 59 - resubmissionAgeSlack,
+			upper:     sampleTime + resubmissionAgeSlack,
+		},
+		{
+			name:      "minutes old",
+			ageApprox: 20 * 60,
+			lower:     sampleTime - 20*60 - 59 - resubmissionAgeSlack,
+			upper:     sampleTime - 20*60 + resubmissionAgeSlack,
+		},
+		{
+			name:      "59 minutes old",
+			ageApprox: 59 * 60,
+			lower:     sampleTime - 59*60 - 59 - resubmissionAgeSlack,
+			upper:     sampleTime - 59*60 + resubmissionAgeSlack,
+		},
+		{
+			name:      "hours old",
+			ageApprox: 3 * 3600,
+			lower:     sampleTime - 3*3600 - 59*60 - resubmissionAgeSlack,
+			upper:     sampleTime - 3*3600 + resubmissionAgeSlack,
+		},
+	}
+
+	for _, tt := range tests {
+		lower, upper := resubmissionWindow(sampleTime, tt.ageApprox)
+		if lower != tt.lower || upper != tt.upper {
+			t.Errorf("%s: resubmissionWindow(%d, %d) = [%d, %d], want [%d, %d]", tt.name, sampleTime, tt.ageApprox, lower, upper, tt.lower, tt.upper)
+		}
+	}
+}
+
+func TestResubmissionConfidence(t *testing.T) {
+	tests := []struct {
+		lower, upper int64
+		want         float64
+	}{
+		{lower: 1000, upper: 1000, want: 1},
+		{lower: 1000, upper: 1000 + 3600, want: 0.5},
+		{lower: 1000, upper: 1000 + 7200, want: 0},
+		{lower: 1000, upper: 1000 + 10000, want: 0},
+	}
+
+	for _, tt := range tests {
+		if got := resubmissionConfidence(tt.lower, tt.upper); got != tt.want {
+			t.Errorf("resubmissionConfidence(%d, %d) = %f, want %f", tt.lower, tt.upper, got, tt.want)
+		}
+	}
+}
@@ -17,6 +17,8 @@ type rawStory struct {
 	ID string
 	row1
 	row2
+	// the HTML of both rows, kept for the parse health report
+	html string
 }
 
 type row1 struct {
@@ -36,29 +38,104 @@ type row2 struct {
 
 type ScrapedStory struct {
 	Story
-	Rank   int
+	Rank int
+	// Source is the page type the story was scraped from ("top", "new",
+	// etc.), or "api" if its details came from the API.
 	Source string
+	// FieldSources records the source of each field of Story that was set.
+	// When a story is on several pages, some fields are merged from other
+	// pages (see mergeScrapedStories).
+	FieldSources map[string]string
 }
 
-func (rs rawStory) Clean() (ScrapedStory, error) {
+// scrapedFields are the fields of Story that are set by the scraper
+var scrapedFields = []string{"Title", "By", "URL", "SubmissionTime", "AgeApprox", "Score", "Comments", "Job", "Flagged", "Dupe"}
+
+func newFieldSources(source string) map[string]string {
+	fieldSources := make(map[string]string, len(scrapedFields))
+	for _, field := range scrapedFields {
+		fieldSources[field] = source
+	}
+	return fieldSources
+}
+
+// mergeScrapedStories merges the details of a story that was scraped from
+// several pages. The pages are fetched at slightly different times, so:
+//
+//   - score and comments are taken from the page with the highest count,
+//     since they only go up
+//   - the story is flagged or a dupe if it is shown as such on any page
+//   - all other fields are taken from the first page in the order top, new,
+//     best, ask, show
+//
+// s has precedence over other.
+func mergeScrapedStories(s, other ScrapedStory) ScrapedStory {
+	if other.Score > s.Score {
+		s.Score = other.Score
+		s.FieldSources["Score"] = other.Source
+	}
+	if other.Comments > s.Comments {
+		s.Comments = other.Comments
+		s.FieldSources["Comments"] = other.Source
+	}
+	if other.Flagged && !s.Flagged {
+		s.Flagged = true
+		s.FieldSources["Flagged"] = other.Source
+	}
+	if other.Dupe && !s.Dupe {
+		s.Dupe = true
+		s.FieldSources["Dupe"] = other.Source
+	}
+	return s
+}
+
+// A storyParseError lists the fields of a story row that failed to parse,
+// by their names in storyFields.
+type storyParseError map[string]error
+
+func (e storyParseError) Error() string {
+	var msgs []string
+	for _, field := range storyFields {
+		if err, ok := e[field]; ok {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Clean parses the fields of a raw story. All fields are parsed even if
+// some fail, so that the parse health report (see parse-health.go) counts
+// failures per field. If any field fails, the error is a storyParseError.
+func (rs rawStory) Clean(pageType string) (ScrapedStory, error) {
 	story := ScrapedStory{
 		Story: Story{
 			Title: rs.Title,
 			By:    rs.Author,
 			URL:   rs.URL,
 		},
-		Source: "scraper",
+		Source:       pageType,
+		FieldSources: newFieldSources(pageType),
 	}
 
+	fieldErrors := storyParseError{}
+
 	// parse id
 	{
 		id, err := strconv.Atoi(rs.ID)
 		if err != nil {
-			return story, errors.Wrapf(err, "parse story id %s", rs.ID)
+			fieldErrors["id"] = errors.Wrapf(err, "parse story id %s", rs.ID)
 		}
 		story.ID = id
 	}
 
+	// every story has a title and URL
+	if rs.Title == "" {
+		fieldErrors["title"] = fmt.Errorf("missing title")
+	}
+	if rs.URL == "" {
+		fieldErrors["url"] = fmt.Errorf("missing url")
+	}
+
 	// fix url
 	if strings.HasPrefix(story.Story.URL, "item?id=") {
 		story.Story.URL = "https://news.ycombinator.com/" + story.Story.URL
@@ -72,13 +149,21 @@ func (rs rawStory) Clean() (ScrapedStory, error) {
 			score, err := strconv.Atoi(scoreStr)
 			story.Score = score
 			if err != nil {
-				return story, errors.Wrapf(err, "parse story score %s", rs.Score)
+				fieldErrors["score"] = errors.Wrapf(err, "parse story score %s", rs.Score)
 			}
+		} else if rs.Author != "" {
+			// jobs have neither a score nor an author, so a missing score
+			// with an author means the score wasn't found
+			fieldErrors["score"] = fmt.Errorf("missing score")
 		} else {
 			// if there is no upvotes field, then this is an HN job.
 			// we want to include these in the database because they get ranked
 			story.Job = true
 		}
+
+		if rs.Author == "" && !story.Job {
+			fieldErrors["by"] = fmt.Errorf("missing author")
+		}
 	}
 
 	// parse submission time
@@ -101,10 +186,11 @@ func (rs rawStory) Clean() (ScrapedStory, error) {
 		}
 
 		if err != nil {
-			return story, errors.Wrapf(err, "parse submission time %s", rs.SubmissionTime)
+			fieldErrors["submissionTime"] = errors.Wrapf(err, "parse submission time %s", rs.SubmissionTime)
+		} else {
+			story.SubmissionTime = submissionTime.Unix()
+			story.OriginalSubmissionTime = story.SubmissionTime
 		}
-		story.SubmissionTime = submissionTime.Unix()
-		story.OriginalSubmissionTime = story.SubmissionTime
 	}
 
 	// parse approximate age
@@ -113,7 +199,7 @@ func (rs rawStory) Clean() (ScrapedStory, error) {
 		if fs := strings.Fields(rs.AgeApprox); len(fs) > 1 {
 			n, err := strconv.Atoi(fs[0])
 			if err != nil {
-				return story, errors.Wrapf(err, "parse relative age %s", rs.AgeApprox)
+				fieldErrors["ageApprox"] = errors.Wrapf(err, "parse relative age %s", rs.AgeApprox)
 			}
 
 			var units int64
@@ -131,53 +217,58 @@ func (rs rawStory) Clean() (ScrapedStory, error) {
 
 			story.AgeApprox = int64(n) * units
 		} else {
-			return story, fmt.Errorf("parse age %s", rs.AgeApprox)
+			fieldErrors["ageApprox"] = fmt.Errorf("parse age %s", rs.AgeApprox)
 		}
+	}
 
-		// parse rank. we know the rank because of the order it appears in.
-		// we just use this to do an integrity check later.
-		{
-			tRank := strings.Trim(rs.Rank, ".")
-			var err error
-			story.Rank, err = strconv.Atoi(tRank)
-			if err != nil || story.Rank == 0 {
-				return story, errors.Wrapf(err, "parse rank %s", rs.Rank)
-			}
+	// parse rank. we know the rank because of the order it appears in.
+	// we just use this to do an integrity check later.
+	{
+		tRank := strings.Trim(rs.Rank, ".")
+		var err error
+		story.Rank, err = strconv.Atoi(tRank)
+		if err != nil || story.Rank == 0 {
+			fieldErrors["rank"] = fmt.Errorf("parse rank %s", rs.Rank)
 		}
+	}
 
-		// parse the number of comments
-		{
-			// if there are comments, this will be the last <a> tag. Unfortunately, it doesn't have an id or class.
-			commentString := rs.Links[len(rs.Links)-1]
-
-			// this string will be a single word like "comment" or "hide" if there are no comments.
-			// otherwise it will be something like "12 comments"
-			if fs := strings.Fields(commentString); len(fs) > 1 {
-				c, err := strconv.Atoi(fs[0])
-				if err != nil {
-					return story, errors.Wrapf(err, "parse comments %s", commentString)
-				}
-				story.Comments = c
+	// parse the number of comments
+	if len(rs.Links) == 0 {
+		fieldErrors["comments"] = fmt.Errorf("missing links")
+	} else {
+		// if there are comments, this will be the last <a> tag. Unfortunately, it doesn't have an id or class.
+		commentString := rs.Links[len(rs.Links)-1]
+
+		// this string will be a single word like "comment" or "hide" if there are no comments.
+		// otherwise it will be something like "12 comments"
+		if fs := strings.Fields(commentString); len(fs) > 1 {
+			c, err := strconv.Atoi(fs[0])
+			if err != nil {
+				fieldErrors["comments"] = errors.Wrapf(err, "parse comments %s", commentString)
 			}
+			story.Comments = c
 		}
+	}
 
-		// parse [flagged] and [dupe] tags
-		{
-			if strings.Contains(rs.FullTitle, "[flagged]") {
-				story.Flagged = true
-			}
-			if strings.Contains(rs.FullTitle, "[dupe]") {
-				story.Dupe = true
-			}
+	// parse [flagged] and [dupe] tags
+	{
+		if strings.Contains(rs.FullTitle, "[flagged]") {
+			story.Flagged = true
 		}
+		if strings.Contains(rs.FullTitle, "[dupe]") {
+			story.Dupe = true
+		}
+	}
 
-		return story, nil
+	if len(fieldErrors) > 0 {
+		return story, fieldErrors
 	}
+	return story, nil
 }
 
-func (app app) newScraper(resultCh chan ScrapedStory, errCh chan error, moreLinkCh chan string) *colly.Collector {
+func (app app) newScraper(ctx context.Context, pageType string, resultCh chan ScrapedStory, errCh chan error, moreLinkCh chan string, report *pageParseReport) *colly.Collector {
 	c := colly.NewCollector()
-	c.SetClient(app.httpClient)
+	c.WithTransport(scraperTransport{ctx, app.storyScraper})
 
 	var rs rawStory
 
@@ -202,6 +293,7 @@ func (app app) newScraper(resultCh chan ScrapedStory, errCh chan error, moreLink
 				rs = rawStory{
 					ID: e.Attr("id"),
 				}
+				rs.html, _ = e.DOM.Html()
 				err := e.Unmarshal(&rs.row1)
 				if err != nil {
 					errCh <- err
@@ -213,19 +305,28 @@ func (app app) newScraper(resultCh chan ScrapedStory, errCh chan error, moreLink
 				// general page content.
 
 				err := e.Unmarshal(&rs.row2)
+				row2HTML, _ := e.DOM.Html()
+				rs.html += "\n" + row2HTML
 
 				if err != nil {
 					errCh <- err
 				} else {
-					st, err := rs.Clean()
+					st, err := rs.Clean(pageType)
 					rank := st.Rank
 
 					// Do an integrity check. If the row shown for the story equals the row
 					// count we are keeping, we area all good.
-					if err == nil && ((rank-1)%30)+1 != n {
-						err = fmt.Errorf("Ranks out of order. Expected %d but parsed %d", n, (rank-1)%30+1)
+					if rank != 0 && ((rank-1)%30)+1 != n {
+						rankErr := fmt.Errorf("Ranks out of order. Expected %d but parsed %d", n, (rank-1)%30+1)
+						if fieldErrors, ok := err.(storyParseError); ok {
+							fieldErrors["rank"] = rankErr
+						} else {
+							err = storyParseError{"rank": rankErr}
+						}
 					}
 
+					report.record(pageType, rs, err)
+
 					if err != nil {
 						Debugf(app.logger, "Failed to parse story %d. Raw story %#v", n, rs)
 						errCh <- err
@@ -245,26 +346,29 @@ func (app app) newScraper(resultCh chan ScrapedStory, errCh chan error, moreLink
 	return c
 }
 
-func (app app) scrapeHN(pageType string, resultCh chan ScrapedStory, errCh chan error) {
-	baseUrl := "https://news.ycombinator.com/"
-	url := baseUrl
+func (app app) scrapeHN(ctx context.Context, pageType string, nPages int, resultCh chan ScrapedStory, errCh chan error, report *pageParseReport) {
+	url := hnBaseURL
 	if pageType == "new" {
 		url = url + "newest"
 	} else if pageType != "top" {
 		url = url + pageType
 	}
-	for p := 1; p <= 3; p++ {
+	for p := 1; p <= nPages; p++ {
+		if ctx.Err() != nil {
+			errCh <- errors.Wrapf(ctx.Err(), "scraping %s page %d", pageType, p)
+			break
+		}
 		moreLinkCh := make(chan string, 1)
-		c := app.newScraper(resultCh, errCh, moreLinkCh)
+		c := app.newScraper(ctx, pageType, resultCh, errCh, moreLinkCh, report)
 		err := c.Visit(url)
 		if err != nil {
 			errCh <- err
 		}
 		select {
 		case relativeURL := <-moreLinkCh:
-			url = baseUrl + relativeURL
+			url = hnBaseURL + relativeURL
 		default:
-			// there won't always be a next link, in particular the show page could have less than 3 pages worth of stories
+			// there won't always be a next link, in particular the show page could have less than nPages pages worth of stories
 		}
 
 	}
@@ -272,58 +376,90 @@ func (app app) scrapeHN(pageType string, resultCh chan ScrapedStory, errCh chan
 	close(errCh)
 }
 
-func (app app) scrapeFrontPageStories(ctx context.Context) (map[int]ScrapedStory, error) {
+// scrapeFrontPageStories scrapes each page type to its crawl depth
+// concurrently, and merges the stories found on several pages with
+// mergeScrapedStories. The parse report is returned even if scraping
+// failed.
+func (app app) scrapeFrontPageStories(ctx context.Context) (map[int]ScrapedStory, parseReport, error) {
 	app.logger.Info("Scraping front page stories")
 
-	stories := map[int]ScrapedStory{}
-
-	pageTypeName := "top"
-
-	nSuccess := 0
+	t := time.Now()
 
-	resultCh := make(chan ScrapedStory)
-	errCh := make(chan error)
+	var storiesByPageType [nPageTypes]map[int]ScrapedStory
+	var report parseReport
 
 	var wg sync.WaitGroup
+	for pageType := top; pageType <= show; pageType++ {
+		pageTypeName := pageTypes[pageType]
+		stories := map[int]ScrapedStory{}
+		storiesByPageType[pageType] = stories
+		pageReport := newPageParseReport()
+		report[pageType] = pageReport
+
+		resultCh := make(chan ScrapedStory)
+		errCh := make(chan error)
+
+		// scrape in a goroutine. the scraper will write results to the channel
+		// we provide
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.scrapeHN(ctx, pageTypeName, app.crawlDepths.pages(pageType), resultCh, errCh, pageReport)
+		}()
+
+		// read from the error channel in print errors in a separate goroutine.
+		// The scraper will block writing to the error channel if nothing is reading
+		// from it.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for err := range errCh {
+				app.logger.Error("Error parsing story", err, "pageType", pageTypeName)
+				crawlErrorsTotal.Inc()
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for story := range resultCh {
+				// the last page may go beyond the crawl depth
+				if story.Rank > app.crawlDepths.ranks(pageType) {
+					continue
+				}
+				stories[story.ID] = story
+			}
+		}()
+	}
 
-	t := time.Now()
+	wg.Wait()
 
-	// scrape in a goroutine. the scraper will write results to the channel
-	// we provide
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		app.scrapeHN(pageTypeName, resultCh, errCh)
-	}()
-
-	// read from the error channel in print errors in a separate goroutine.
-	// The scraper will block writing to the error channel if nothing is reading
-	// from it.
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		for err := range errCh {
-			app.logger.Error("Error parsing story", err)
-			crawlErrorsTotal.Inc()
+	stories := map[int]ScrapedStory{}
+	for pageType := top; pageType <= show; pageType++ {
+		pageTypeName := pageTypes[pageType]
+		nSuccess := len(storiesByPageType[pageType])
+		report[pageType].Scraped = nSuccess
+
+		if nSuccess == 0 {
+			app.logger.Warn("Didn't successfully parse any stories", "pageType", pageTypeName)
+			continue
 		}
-	}()
-
-	for story := range resultCh {
-		id := story.ID
+		Debugf(app.logger, "Crawled %d stories on %s page", nSuccess, pageTypeName)
 
-		stories[id] = story
-
-		nSuccess += 1
+		for id, story := range storiesByPageType[pageType] {
+			if s, ok := stories[id]; ok {
+				stories[id] = mergeScrapedStories(s, story)
+			} else {
+				stories[id] = story
+			}
+		}
 	}
 
-	if nSuccess == 0 {
-		return stories, fmt.Errorf("Didn't successfully parse any stories from %s page", pageTypeName)
+	if len(storiesByPageType[top]) == 0 {
+		return stories, report, fmt.Errorf("Didn't successfully parse any stories from top page")
 	}
-	Debugf(app.logger, "Crawled %d stories on %s page", nSuccess, pageTypeName)
-
-	wg.Wait()
 
-	app.logger.Info("Scraped stories", "pageTypeName", pageTypeName, slog.Duration("elapsed", time.Since(t)))
+	app.logger.Info("Scraped stories", "nstories", len(stories), slog.Duration("elapsed", time.Since(t)))
 
-	return stories, nil
+	return stories, report, nil
 }
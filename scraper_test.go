@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergeScrapedStories(t *testing.T) {
+	scraped := func(source string, score, comments int, flagged, dupe bool) ScrapedStory {
+		return ScrapedStory{
+			Story:        Story{ID: 1, Title: source + " title", Score: score, Comments: comments, Flagged: flagged, Dupe: dupe},
+			Source:       source,
+			FieldSources: newFieldSources(source),
+		}
+	}
+
+	tests := []struct {
+		name    string
+		s       ScrapedStory
+		other   ScrapedStory
+		want    Story
+		sources map[string]string
+	}{
+		{
+			name:    "higher counts on the other page",
+			s:       scraped("top", 10, 2, false, false),
+			other:   scraped("new", 12, 3, false, false),
+			want:    Story{ID: 1, Title: "top title", Score: 12, Comments: 3},
+			sources: map[string]string{"Title": "top", "Score": "new", "Comments": "new"},
+		},
+		{
+			name:    "lower counts on the other page",
+			s:       scraped("top", 10, 2, false, false),
+			other:   scraped("best", 9, 1, false, false),
+			want:    Story{ID: 1, Title: "top title", Score: 10, Comments: 2},
+			sources: map[string]string{"Title": "top", "Score": "top", "Comments": "top"},
+		},
+		{
+			name:    "flagged on the other page",
+			s:       scraped("top", 10, 2, false, false),
+			other:   scraped("new", 10, 2, true, false),
+			want:    Story{ID: 1, Title: "top title", Score: 10, Comments: 2, Flagged: true},
+			sources: map[string]string{"Flagged": "new", "Dupe": "top"},
+		},
+		{
+			name:    "dupe on the first page",
+			s:       scraped("top", 10, 2, false, true),
+			other:   scraped("new", 10, 2, false, false),
+			want:    Story{ID: 1, Title: "top title", Score: 10, Comments: 2, Dupe: true},
+			sources: map[string]string{"Flagged": "top", "Dupe": "top"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := mergeScrapedStories(tt.s, tt.other)
+		if got.Story != tt.want {
+			t.Errorf("%s: merged story = %+v, want %+v", tt.name, got.Story, tt.want)
+		}
+		if got.Source != tt.s.Source {
+			t.Errorf("%s: merged source = %q, want %q", tt.name, got.Source, tt.s.Source)
+		}
+		for field, want := range tt.sources {
+			if got.FieldSources[field] != want {
+				t.Errorf("%s: source of %s = %q, want %q", tt.name, field, got.FieldSources[field], want)
+			}
+		}
+	}
+}
+
+func TestRawStoryClean(t *testing.T) {
+	valid := rawStory{
+		ID: "1000",
+		row1: row1{
+			Title:     "Story",
+			FullTitle: "Story (example.com)",
+			URL:       "https://example.com",
+			Rank:      "3.",
+		},
+		row2: row2{
+			Author:         "user",
+			Score:          "42 points",
+			SubmissionTime: "2024-10-23T16:44:01 1729713776",
+			AgeApprox:      "2 hours ago",
+			Links:          []string{"user", "2 hours ago", "hide", "12 comments"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		edit func(rs *rawStory)
+		// fields that should fail to parse
+		failed []string
+	}{
+		{name: "valid", edit: func(rs *rawStory) {}},
+		{name: "job", edit: func(rs *rawStory) { rs.Author, rs.Score = "", "" }},
+		{name: "bad id", edit: func(rs *rawStory) { rs.ID = "x" }, failed: []string{"id"}},
+		{name: "missing score", edit: func(rs *rawStory) { rs.Score = "" }, failed: []string{"score"}},
+		{name: "missing author", edit: func(rs *rawStory) { rs.Author = "" }, failed: []string{"by"}},
+		{
+			name:   "layout change",
+			edit:   func(rs *rawStory) { rs.Title, rs.URL, rs.Rank, rs.Links = "", "", "", nil },
+			failed: []string{"title", "url", "rank", "comments"},
+		},
+		{name: "bad age", edit: func(rs *rawStory) { rs.AgeApprox = "yesterday" }, failed: []string{"ageApprox"}},
+		{name: "bad submission time", edit: func(rs *rawStory) { rs.SubmissionTime = "" }, failed: []string{"submissionTime"}},
+	}
+
+	for _, tt := range tests {
+		rs := valid
+		tt.edit(&rs)
+
+		_, err := rs.Clean("top")
+		if len(tt.failed) == 0 {
+			if err != nil {
+				t.Errorf("%s: Clean returned error: %v", tt.name, err)
+			}
+			continue
+		}
+
+		parseErr, ok := err.(storyParseError)
+		if !ok {
+			t.Errorf("%s: Clean returned %v, want a storyParseError", tt.name, err)
+			continue
+		}
+		if len(parseErr) != len(tt.failed) {
+			t.Errorf("%s: fields %v failed, want %v", tt.name, parseErr, tt.failed)
+		}
+		for _, field := range tt.failed {
+			if parseErr[field] == nil {
+				t.Errorf("%s: field %s didn't fail", tt.name, field)
+			}
+		}
+	}
+}
+
+func TestStoryParseError(t *testing.T) {
+	err := storyParseError{
+		"comments": errors.New("missing links"),
+		"id":       errors.New("parse story id x"),
+		"rank":     errors.New("parse rank "),
+	}
+
+	// fields are listed in the order of storyFields
+	want := "parse story id x; parse rank ; missing links"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,110 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Built with the sqlite_fts5 tag, which also enables FTS5 in go-sqlite3, the
+// text of story items is indexed in the story_items_fts table. Triggers keep
+// the index in sync with story_items. The index is built when it is
+// created, and rebuilt if the database was since written by a build without
+// FTS5, which marks it in stale_search_indexes.
+//
+// The title, URL, domain and author of stories are indexed in the
+// stories_fts table, with the story ID as rowid, which insertOrReplaceStory
+// and purgeStory keep in sync with stories. It is built and rebuilt like
+// story_items_fts.
+const fts5Enabled = true
+
+var storyItemsIndexStatements = []string{
+	`
+	CREATE VIRTUAL TABLE IF NOT EXISTS story_items_fts USING fts5(
+		text, content='story_items', content_rowid='id'
+	)
+	`,
+	`
+	CREATE TRIGGER IF NOT EXISTS story_items_fts_insert AFTER INSERT ON story_items BEGIN
+		INSERT INTO story_items_fts(rowid, text) VALUES (new.id, new.text);
+	END
+	`,
+	`
+	CREATE TRIGGER IF NOT EXISTS story_items_fts_delete AFTER DELETE ON story_items BEGIN
+		INSERT INTO story_items_fts(story_items_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	END
+	`,
+	`
+	CREATE TRIGGER IF NOT EXISTS story_items_fts_update AFTER UPDATE ON story_items BEGIN
+		INSERT INTO story_items_fts(story_items_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		INSERT INTO story_items_fts(rowid, text) VALUES (new.id, new.text);
+	END
+	`,
+}
+
+var storiesIndexStatements = []string{
+	`
+	CREATE VIRTUAL TABLE IF NOT EXISTS stories_fts USING fts5(
+		title, url, domain, by, content='stories', content_rowid='id'
+	)
+	`,
+}
+
+func (ndb newsDatabase) initSearchIndexes() error {
+	if err := ndb.createSearchIndex("story_items_fts", storyItemsIndexStatements); err != nil {
+		return err
+	}
+	return ndb.createSearchIndex("stories_fts", storiesIndexStatements)
+}
+
+// createSearchIndex executes the statements that create the external
+// content FTS5 table name, and rebuilds the index from the content table if
+// the table was just created or is marked as stale.
+func (ndb newsDatabase) createSearchIndex(name string, statements []string) error {
+	var exists, stale bool
+	err := ndb.db.QueryRow(`
+		select
+			exists(select 1 from sqlite_master where type = 'table' and name = ?1)
+			, exists(select 1 from stale_search_indexes where name = ?1)
+	`, name).Scan(&exists, &stale)
+	if err != nil {
+		return errors.Wrap(err, "selecting search index status")
+	}
+
+	for _, s := range statements {
+		if _, err := ndb.db.Exec(s); err != nil {
+			return errors.Wrapf(err, "creating search index: %s", s)
+		}
+	}
+
+	if exists && !stale {
+		return nil
+	}
+
+	_, err = ndb.db.Exec(fmt.Sprintf(`INSERT INTO %[1]s(%[1]s) VALUES ('rebuild')`, name))
+	if err != nil {
+		return errors.Wrapf(err, "rebuilding %s", name)
+	}
+
+	_, err = ndb.db.Exec(`DELETE FROM stale_search_indexes WHERE name = ?`, name)
+	return errors.Wrap(err, "delete from stale_search_indexes")
+}
+
+// parameters: FTS5 query, type, type, limit
+const storyItemsSearchSQL = `
+	select i.id, s.title, s.by, s.timestamp, i.type, i.kids, i.text
+	from story_items_fts
+	join story_items i on i.id = story_items_fts.rowid
+	join stories s on s.id = i.id
+	where story_items_fts match ?
+	and (? = '' or i.type = ?)
+	order by story_items_fts.rank
+	limit ?
+`
+
+// parameters: :query (FTS5 query)
+const storiesMatchSQL = `
+	select rowid as id, rank from stories_fts where stories_fts match :query
+`
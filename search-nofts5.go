@@ -0,0 +1,48 @@
+//go:build !sqlite_fts5
+
+package main
+
+import "github.com/pkg/errors"
+
+// Without the sqlite_fts5 tag, go-sqlite3 is built without FTS5, so story
+// items and stories are searched with like. The triggers that keep the FTS5
+// index in sync are dropped, since inserts into story_items would fail
+// without FTS5, and the indexes are marked as stale, so that a build with
+// FTS5 rebuilds them.
+const fts5Enabled = false
+
+var searchIndexStatements = []string{
+	`DROP TRIGGER IF EXISTS story_items_fts_insert`,
+	`DROP TRIGGER IF EXISTS story_items_fts_delete`,
+	`DROP TRIGGER IF EXISTS story_items_fts_update`,
+	`INSERT OR IGNORE INTO stale_search_indexes(name) VALUES ('story_items_fts'), ('stories_fts')`,
+}
+
+func (ndb newsDatabase) initSearchIndexes() error {
+	for _, s := range searchIndexStatements {
+		if _, err := ndb.db.Exec(s); err != nil {
+			return errors.Wrapf(err, "creating search indexes: %s", s)
+		}
+	}
+	return nil
+}
+
+// parameters: text to search for, type, type, limit
+const storyItemsSearchSQL = `
+	select i.id, s.title, s.by, s.timestamp, i.type, i.kids, i.text
+	from story_items i
+	join stories s on s.id = i.id
+	where i.text like '%' || ? || '%'
+	and (? = '' or i.type = ?)
+	order by s.timestamp desc
+	limit ?
+`
+
+// parameters: :query (text to search for)
+const storiesMatchSQL = `
+	select id, 0 as rank from stories
+	where title like '%' || :query || '%'
+	or url like '%' || :query || '%'
+	or domain like '%' || :query || '%'
+	or by like '%' || :query || '%'
+`
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/exp/slog"
+)
+
+var simulateSQL = readSQLSource("simulate.sql")
+
+// named formulas that can be passed to the simulate command instead of SQL
+var simulationFormulas = map[string]string{
+	"qn": qnRankFormulaSQL,
+	"hn": hnRankFormulaSQL,
+}
+
+// simulatedStory summarizes how a story would have done under a simulated
+// ranking, compared to how it actually did on the HN front page. Front page
+// time and expected upvotes use the time since the previous crawl.
+type simulatedStory struct {
+	id                 int
+	crawls             int
+	bestRank           int
+	bestTopRank        int
+	frontPageTime      int
+	topFrontPageTime   int
+	expectedUpvotes    float64
+	topExpectedUpvotes float64
+}
+
+func (s simulatedStory) expectedUpvotesGain() float64 {
+	return s.expectedUpvotes - s.topExpectedUpvotes
+}
+
+func minRank(current, rank int) int {
+	if current == 0 || rank < current {
+		return rank
+	}
+	return current
+}
+
+// runSimulateCommand re-ranks every crawl in a time window using a ranking
+// formula, and compares the results to the actual ranks on the HN front
+// page. The rank of every story in every crawl is written to a CSV file, and
+// a per-story summary (best rank, time on the front page, and the upvotes
+// an average story would have received at those ranks) to another.
+func runSimulateCommand(ctx context.Context, logger *slog.Logger, args []string) error {
+	p := defaultFrontPageParams
+
+	flags := flag.NewFlagSet("simulate", flag.ExitOnError)
+	dataDir := flags.String("data-dir", os.Getenv("SQLITE_DATA_DIR"), "directory containing the database")
+	formula := flags.String("formula", "qn", "ranking formula: \"qn\", \"hn\", or an SQL order by expression over the columns used in qnranks.sql")
+	fromString := flags.String("from", "", "start of the time window, in RFC3339 format (default: 24 hours before -to)")
+	toString := flags.String("to", "", "end of the time window, in RFC3339 format (default: the last crawl)")
+	out := flags.String("out", "simulation", "prefix of the output files")
+	flags.Float64Var(&p.PriorWeight, "prior-weight", p.PriorWeight, "priorWeight parameter")
+	flags.Float64Var(&p.OverallPriorWeight, "overall-prior-weight", p.OverallPriorWeight, "overallPriorWeight parameter")
+	flags.Float64Var(&p.Gravity, "gravity", p.Gravity, "gravity parameter")
+	flags.Float64Var(&p.PenaltyWeight, "penalty-weight", p.PenaltyWeight, "penaltyWeight parameter")
+	flags.Float64Var(&p.FatigueFactor, "fatigue-factor", p.FatigueFactor, "fatigueFactor parameter")
+	_ = flags.Parse(args)
+
+	if *dataDir == "" {
+		flags.Usage()
+		return errors.New("-data-dir or SQLITE_DATA_DIR is required")
+	}
+
+	orderBy, ok := simulationFormulas[*formula]
+	if !ok {
+		orderBy = *formula
+	}
+
+	ndb, err := openNewsDatabase(*dataDir, logger)
+	if err != nil {
+		return errors.Wrap(err, "openNewsDatabase")
+	}
+	defer ndb.close()
+
+	var to, from time.Time
+	if *toString != "" {
+		if to, err = time.Parse(time.RFC3339, *toString); err != nil {
+			return errors.Wrap(err, "parsing -to")
+		}
+	} else {
+		lastCrawlTime, err := ndb.selectLastCrawlTime()
+		if err != nil {
+			return errors.Wrap(err, "selectLastCrawlTime")
+		}
+		to = time.Unix(int64(lastCrawlTime), 0)
+	}
+	if *fromString != "" {
+		if from, err = time.Parse(time.RFC3339, *fromString); err != nil {
+			return errors.Wrap(err, "parsing -from")
+		}
+	} else {
+		from = to.Add(-24 * time.Hour)
+	}
+
+	trajectoriesFile, err := os.Create(*out + "-trajectories.csv")
+	if err != nil {
+		return errors.Wrap(err, "creating trajectories file")
+	}
+	defer trajectoriesFile.Close()
+
+	trajectories := csv.NewWriter(trajectoriesFile)
+	_ = trajectories.Write([]string{"sampleTime", "id", "rank", "topRank"})
+
+	logger.Info("Simulating ranking formula", "from", from, "to", to, "formula", orderBy)
+
+	query := fmt.Sprintf(simulateSQL, p.PriorWeight, p.OverallPriorWeight, p.Gravity, p.PenaltyWeight, p.FatigueFactor, orderBy)
+
+	rows, err := ndb.db.QueryContext(ctx, query, from.Unix(), to.Unix(), maxElapsedTime, defaultCrawlDepth)
+	if err != nil {
+		return errors.Wrap(err, "executing simulation SQL")
+	}
+	defer rows.Close()
+
+	stories := make(map[int]*simulatedStory)
+	crawls := make(map[int64]bool)
+
+	for rows.Next() {
+		var id, elapsedTime int
+		var sampleTime int64
+		var rank, topRank sql.NullInt64
+		var sitewideUpvotes float64
+
+		if err := rows.Scan(&id, &sampleTime, &rank, &topRank, &elapsedTime, &sitewideUpvotes); err != nil {
+			return errors.Wrap(err, "rows.Scan")
+		}
+		crawls[sampleTime] = true
+
+		s, ok := stories[id]
+		if !ok {
+			s = &simulatedStory{id: id}
+			stories[id] = s
+		}
+		s.crawls++
+
+		if elapsedTime > maxElapsedTime {
+			elapsedTime = maxElapsedTime
+		}
+		// sitewideUpvotes is per minute
+		upvotesSinceLastCrawl := sitewideUpvotes * float64(elapsedTime) / 60
+
+		if rank.Valid {
+			r := int(rank.Int64)
+			s.bestRank = minRank(s.bestRank, r)
+			if r <= 30 {
+				s.frontPageTime += elapsedTime
+			}
+			s.expectedUpvotes += expectedUpvoteShare(top, r) * upvotesSinceLastCrawl
+		}
+
+		if topRank.Valid {
+			r := int(topRank.Int64)
+			s.bestTopRank = minRank(s.bestTopRank, r)
+			if r <= 30 {
+				s.topFrontPageTime += elapsedTime
+			}
+			s.topExpectedUpvotes += expectedUpvoteShare(top, r) * upvotesSinceLastCrawl
+		}
+
+		_ = trajectories.Write([]string{
+			strconv.FormatInt(sampleTime, 10),
+			strconv.Itoa(id),
+			nullIntString(rank),
+			nullIntString(topRank),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return errors.Wrap(err, "executing simulation SQL")
+	}
+
+	trajectories.Flush()
+	if err := trajectories.Error(); err != nil {
+		return errors.Wrap(err, "writing trajectories")
+	}
+
+	summary := make([]simulatedStory, 0, len(stories))
+	for _, s := range stories {
+		summary = append(summary, *s)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].expectedUpvotesGain() > summary[j].expectedUpvotesGain() })
+
+	titles, err := ndb.selectStoryTitles(ctx, summary)
+	if err != nil {
+		return errors.Wrap(err, "selectStoryTitles")
+	}
+
+	if err := writeSimulationSummary(*out+"-stories.csv", summary, titles); err != nil {
+		return errors.Wrap(err, "writeSimulationSummary")
+	}
+
+	printSimulationReport(len(crawls), summary, titles)
+
+	logger.Info("Wrote simulation results", "trajectories", trajectoriesFile.Name(), "stories", *out+"-stories.csv")
+
+	return nil
+}
+
+func nullIntString(n sql.NullInt64) string {
+	if !n.Valid {
+		return ""
+	}
+	return strconv.FormatInt(n.Int64, 10)
+}
+
+func (ndb newsDatabase) selectStoryTitles(ctx context.Context, stories []simulatedStory) (map[int]string, error) {
+	stmt, err := ndb.db.PrepareContext(ctx, "select title from stories where id = ?")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	titles := make(map[int]string, len(stories))
+	for _, s := range stories {
+		var title string
+		err := stmt.QueryRowContext(ctx, s.id).Scan(&title)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		titles[s.id] = title
+	}
+
+	return titles, nil
+}
+
+func writeSimulationSummary(filename string, summary []simulatedStory, titles map[int]string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"id", "title", "crawls", "bestRank", "bestTopRank", "frontPageMinutes", "topFrontPageMinutes", "expectedUpvotes", "topExpectedUpvotes"})
+	for _, s := range summary {
+		_ = w.Write([]string{
+			strconv.Itoa(s.id),
+			titles[s.id],
+			strconv.Itoa(s.crawls),
+			strconv.Itoa(s.bestRank),
+			strconv.Itoa(s.bestTopRank),
+			strconv.FormatFloat(float64(s.frontPageTime)/60, 'f', 1, 64),
+			strconv.FormatFloat(float64(s.topFrontPageTime)/60, 'f', 1, 64),
+			strconv.FormatFloat(s.expectedUpvotes, 'f', 2, 64),
+			strconv.FormatFloat(s.topExpectedUpvotes, 'f', 2, 64),
+		})
+	}
+	w.Flush()
+
+	return w.Error()
+}
+
+// printSimulationReport prints totals and the stories that would gain and
+// lose the most attention. summary must be sorted by expectedUpvotesGain.
+func printSimulationReport(nCrawls int, summary []simulatedStory, titles map[int]string) {
+	var onFrontPage, onTopFrontPage, onBoth int
+	for _, s := range summary {
+		if s.frontPageTime > 0 {
+			onFrontPage++
+		}
+		if s.topFrontPageTime > 0 {
+			onTopFrontPage++
+		}
+		if s.frontPageTime > 0 && s.topFrontPageTime > 0 {
+			onBoth++
+		}
+	}
+
+	fmt.Printf("%d crawls, %d stories\n", nCrawls, len(summary))
+	fmt.Printf("stories on the front page: %d with the formula, %d on HN, %d on both\n\n", onFrontPage, onTopFrontPage, onBoth)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	printStories := func(heading string, stories []simulatedStory) {
+		fmt.Fprintln(w, heading)
+		fmt.Fprintln(w, "id\tbest rank\tbest topRank\tfront page minutes\ttopRank front page minutes\texpected upvotes\ttopRank expected upvotes\ttitle")
+		for _, s := range stories {
+			fmt.Fprintf(w, "%d\t%d\t%d\t%.0f\t%.0f\t%.1f\t%.1f\t%s\n",
+				s.id, s.bestRank, s.bestTopRank, float64(s.frontPageTime)/60, float64(s.topFrontPageTime)/60, s.expectedUpvotes, s.topExpectedUpvotes, titles[s.id])
+		}
+		fmt.Fprintln(w)
+	}
+
+	n := 10
+	if len(summary) < 2*n {
+		n = len(summary) / 2
+	}
+
+	printStories("Largest gains in attention:", summary[:n])
+
+	losers := make([]simulatedStory, n)
+	for i := range losers {
+		losers[i] = summary[len(summary)-1-i]
+	}
+	printStories("Largest losses in attention:", losers)
+
+	_ = w.Flush()
+}
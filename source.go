@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/johnwarden/hn"
+	"github.com/pkg/errors"
+)
+
+const hnBaseURL = "https://news.ycombinator.com/"
+
+// RankSource provides the ranked story IDs for each page type, the
+// details of individual items, and the recently changed items. In
+// production this is the Hacker News API: *hn.Client implements this
+// interface.
+type RankSource interface {
+	Stories(ctx context.Context, pageType string) ([]int, error)
+	GetItems(ctx context.Context, ids []int, maxGoroutines int) ([]hn.Item, error)
+	Updates(ctx context.Context) (*hn.Updates, error)
+	MaxItem(ctx context.Context) (int, error)
+}
+
+// StoryScraper fetches the HTML of Hacker News listing pages. The path is
+// relative to hnBaseURL, for example "" for the front page, "newest", or
+// "?p=2".
+type StoryScraper interface {
+	FetchPage(ctx context.Context, path string) ([]byte, error)
+}
+
+// httpStoryScraper fetches pages from the live Hacker News site.
+type httpStoryScraper struct {
+	client *http.Client
+}
+
+func (s httpStoryScraper) FetchPage(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, hnBaseURL+path, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "http.NewRequest")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fetching %s", req.URL)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", req.URL, resp.Status)
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	return b, errors.Wrapf(err, "reading %s", req.URL)
+}
+
+// scraperTransport is an http.RoundTripper that gets responses from a
+// StoryScraper. It lets colly parse pages from any StoryScraper as if
+// they came from hnBaseURL. colly doesn't pass a context with its requests,
+// so requests are made with the transport's context, which carries the
+// crawl deadline.
+type scraperTransport struct {
+	ctx     context.Context
+	scraper StoryScraper
+}
+
+func (t scraperTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := strings.TrimPrefix(req.URL.String(), hnBaseURL)
+
+	b, err := t.scraper.FetchPage(t.ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:          io.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
+
+// fixtureSource is a RankSource and StoryScraper that reads recorded API
+// responses and HTML pages from a directory, so the crawler can run without
+// network access. The directory layout is:
+//
+//	api/<pageType>stories.json   ranked story IDs (e.g. api/topstories.json)
+//	api/item/<id>.json           item details
+//	api/updates.json             recently changed items
+//	api/maxitem.json             the largest item ID
+//	html/<page>.html             listing pages, named by fixtureFileName
+//
+// Any of these files may be gzipped, with an additional .gz extension.
+type fixtureSource struct {
+	dir string
+}
+
+// fixtureFileName converts the path of a listing page into a file name:
+// "" becomes "news" and any characters that are not safe in file names are
+// replaced by underscores (so "?p=2" becomes "news_p=2").
+func fixtureFileName(path string) string {
+	if path == "" || strings.HasPrefix(path, "?") {
+		path = "news" + path
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '?', '&', '/', '\\', ':':
+			return '_'
+		}
+		return r
+	}, path) + ".html"
+}
+
+func (s fixtureSource) readFile(name string) ([]byte, error) {
+	filename := filepath.Join(s.dir, name)
+
+	b, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		var compressed []byte
+		compressed, err = os.ReadFile(filename + ".gz")
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading fixture %s", name)
+		}
+
+		var gzipReader *gzip.Reader
+		gzipReader, err = gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, errors.Wrapf(err, "decompressing fixture %s", name)
+		}
+		defer gzipReader.Close()
+
+		b, err = io.ReadAll(gzipReader)
+		return b, errors.Wrapf(err, "decompressing fixture %s", name)
+	}
+
+	return b, errors.Wrapf(err, "reading fixture %s", name)
+}
+
+func (s fixtureSource) Stories(ctx context.Context, pageType string) ([]int, error) {
+	b, err := s.readFile(filepath.Join("api", pageType+"stories.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	err = json.Unmarshal(b, &ids)
+	return ids, errors.Wrapf(err, "parsing %s stories fixture", pageType)
+}
+
+func (s fixtureSource) GetItems(ctx context.Context, ids []int, maxGoroutines int) ([]hn.Item, error) {
+	items := make([]hn.Item, len(ids))
+
+	for i, id := range ids {
+		b, err := s.readFile(filepath.Join("api", "item", fmt.Sprintf("%d.json", id)))
+		if err != nil {
+			return items, err
+		}
+
+		if err = json.Unmarshal(b, &items[i]); err != nil {
+			return items, errors.Wrapf(err, "parsing item fixture %d", id)
+		}
+
+		// Mirror the behavior of hn.Client, which fills in the URL for text posts.
+		if items[i].Type == "story" && items[i].URL == "" {
+			items[i].URL = fmt.Sprintf("https://news.ycombinator.com/item?id=%d", id)
+		}
+	}
+
+	return items, nil
+}
+
+func (s fixtureSource) Updates(ctx context.Context) (*hn.Updates, error) {
+	b, err := s.readFile(filepath.Join("api", "updates.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var updates hn.Updates
+	err = json.Unmarshal(b, &updates)
+	return &updates, errors.Wrap(err, "parsing updates fixture")
+}
+
+func (s fixtureSource) MaxItem(ctx context.Context) (int, error) {
+	b, err := s.readFile(filepath.Join("api", "maxitem.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	var maxItem int
+	err = json.Unmarshal(b, &maxItem)
+	return maxItem, errors.Wrap(err, "parsing maxitem fixture")
+}
+
+func (s fixtureSource) FetchPage(ctx context.Context, path string) ([]byte, error) {
+	return s.readFile(filepath.Join("html", fixtureFileName(path)))
+}
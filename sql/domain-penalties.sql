@@ -0,0 +1,28 @@
+-- Estimates the penalty applied by HN to each story on the front page since
+-- a sampleTime (?1), for learning domain penalties. If a story is ranked at
+-- topRank, HN's ranking score for the story is about the same as the
+-- pre-penalty ranking score of the story at that rawRank. So the penalty is
+-- one minus the ratio of that ranking score to the story's own pre-penalty
+-- ranking score. Boosted stories get a negative penalty. Returns the average
+-- penalty of each story.
+--
+-- Only the crawls since ?1 are read, and the story at rawRank is looked up
+-- with the dataset_sampletime_rawrank index, so the ranking scores are
+-- computed on the dataset rows rather than in a CTE.
+with penalties as (
+  select
+    s.id
+    , 1 - (pow(implied.score-1, 0.8) / pow(cast(implied.sampleTime - implied.submissionTime as real)/3600+2, 1.8)) -- pre-penalty HN ranking formula
+      / (pow(s.score-1, 0.8) / pow(cast(s.sampleTime - s.submissionTime as real)/3600+2, 1.8)) as penalty
+  from dataset s indexed by dataset_sampletime_id
+  join dataset implied on implied.sampleTime = s.sampleTime and implied.rawRank = s.topRank
+  where s.sampleTime >= ?1
+  and s.topRank is not null
+  and s.rawRank is not null
+  and s.score > 1
+)
+select id, url, avg(penalty)
+from penalties join stories using (id)
+where not job
+and url != ''
+group by id;
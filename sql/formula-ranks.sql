@@ -0,0 +1,29 @@
+-- Ranks the stories in the latest crawl according to a named ranking formula
+-- (see ranking-formulas.go). Stories are eligible under the same conditions
+-- as in qnranks.sql.
+with parameters as (select %f as priorWeight, %f as overallPriorWeight, %f as gravity, %f as penaltyWeight, %f as fatigueFactor)
+, latestData as (
+	select
+		id
+		, score
+		, sampleTime
+		, cast(sampleTime-submissionTime as real)/3600 as ageHours
+		, cumulativeUpvotes
+		, cumulativeExpectedUpvotes
+		, penalty
+	from dataset
+	where sampleTime = (select max(sampleTime) from dataset)
+	and score >= 3 -- story can't reach front page until score >= 3
+	and coalesce(topRank, bestRank, newRank, askRank, showRank) is not null
+)
+, formulaRanks as (
+	select
+		id
+		, sampleTime
+		, dense_rank() over(order by %s) as rank
+	from latestData join parameters
+)
+insert into formula_ranks(id, sampleTime, formula, rank)
+select id, sampleTime, ?, rank
+from formulaRanks
+where rank <= 90;
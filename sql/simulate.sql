@@ -0,0 +1,59 @@
+-- Re-ranks every crawl between two sampleTimes (?1 and ?2) using a custom
+-- ranking formula, for the simulate command. Stories are eligible for
+-- ranking under the same conditions as in qnranks.sql. Sitewide upvotes are
+-- counted as in the crawler: over intervals shorter than maxElapsedTime (?3),
+-- from stories within the default crawl depth (?4).
+with parameters as (select %f as priorWeight, %f as overallPriorWeight, %f as gravity, %f as penaltyWeight, %f as fatigueFactor)
+, crawls as (
+  select
+    sampleTime
+    , sampleTime - lag(sampleTime) over (order by sampleTime) as elapsedTime
+  from (select distinct sampleTime from dataset where sampleTime between ?1 and ?2)
+)
+, deltas as (
+  select
+    sampleTime
+    , score - lag(score) over (partition by id order by sampleTime) as upvotes
+    , sampleTime - lag(sampleTime) over (partition by id order by sampleTime) as storyElapsedTime
+    , min(
+      ifnull(topRank, 1e9), ifnull(newRank, 1e9), ifnull(bestRank, 1e9),
+      ifnull(askRank, 1e9), ifnull(showRank, 1e9)
+    ) as minRank
+  from dataset
+  where sampleTime between ?1 - ?3 and ?2
+)
+, sitewide as (
+  select sampleTime, sum(upvotes * 60.0 / storyElapsedTime) as sitewideUpvotes
+  from deltas
+  where storyElapsedTime < ?3
+  and minRank <= ?4
+  group by sampleTime
+)
+, candidates as (
+  select
+    id
+    , sampleTime
+    , cast(sampleTime-submissionTime as real)/3600 as ageHours
+    , score
+    , cumulativeUpvotes
+    , cumulativeExpectedUpvotes
+    , penalty
+    , topRank
+    , score >= 3 and coalesce(topRank, bestRank, newRank, askRank, showRank) is not null as eligible
+  from dataset
+  where sampleTime between ?1 and ?2
+)
+, simulatedRanks as (
+  select
+    id
+    , sampleTime
+    , topRank
+    , case when eligible then dense_rank() over (partition by sampleTime, eligible order by %s) end as rank
+  from candidates join parameters
+)
+select id, sampleTime, rank, topRank, ifnull(elapsedTime, 0), ifnull(sitewideUpvotes, 0)
+from simulatedRanks
+join crawls using (sampleTime)
+left join sitewide using (sampleTime)
+where rank <= 90 or topRank is not null
+order by sampleTime;
@@ -24,6 +24,7 @@ type StatsData struct {
 	RanksPlotDataJSON   template.JS
 	UpvotesPlotDataJSON template.JS
 	MaxSampleTime       int
+	Penalty             float64
 }
 
 type StatsPageData struct {
@@ -31,12 +32,39 @@ type StatsPageData struct {
 	EstimatedUpvoteRate int
 	StoryTemplateData
 	StatsData
+	Events        []Event
+	Resubmissions []Resubmission
+	Revisions     []StoryRevision
+	Item          StoryItem
+}
+
+// EventsJSON lists the start and end times of the story's events, for
+// marking them on the ranks plot.
+func (s StatsPageData) EventsJSON() template.JS {
+	events := make([][]any, len(s.Events))
+	for i, e := range s.Events {
+		var endTime any
+		if e.EndTime.Valid {
+			endTime = e.EndTime.Int64
+		}
+		events[i] = []any{e.StartTime, endTime, e.Type}
+	}
+	b, _ := json.Marshal(events)
+	return template.JS(b)
 }
 
 func (s StatsPageData) MaxSampleTimeISOString() string {
 	return time.Unix(int64(s.MaxSampleTime), 0).UTC().Format("2006-01-02T15:04")
 }
 
+func (s StatsPageData) PenaltyString() string {
+	return fmt.Sprintf("%.2f", s.Penalty)
+}
+
+func (s StatsPageData) PenaltyWeightString() string {
+	return fmt.Sprintf("%.2f", defaultFrontPageParams.PenaltyWeight)
+}
+
 func (s StatsPageData) OriginalSubmissionTimeISOString() string {
 	return time.Unix(s.OriginalSubmissionTime, 0).UTC().Format("2006-01-02T15:04")
 }
@@ -55,6 +83,7 @@ func (app app) statsPage(w io.Writer, r *http.Request, params StatsPageParams, u
 
 	modelParams := params.OptionalModelParams.WithDefaults()
 	s.UpvoteRate = modelParams.upvoteRate(s.CumulativeUpvotes, s.CumulativeExpectedUpvotes)
+	s.CommentRate = modelParams.commentRate(s.CumulativeComments, s.CumulativeExpectedComments)
 
 	pageTemplate := PageTemplateData{
 		UserID: userID,
@@ -65,11 +94,35 @@ func (app app) statsPage(w io.Writer, r *http.Request, params StatsPageParams, u
 		PageTemplateData: pageTemplate,
 	}
 
+	events, err := app.ndb.selectEvents(r.Context(), params.StoryID)
+	if err != nil {
+		return errors.Wrap(err, "selectEvents")
+	}
+
+	resubmissions, err := app.ndb.selectResubmissions(r.Context(), params.StoryID)
+	if err != nil {
+		return errors.Wrap(err, "selectResubmissions")
+	}
+
+	revisions, err := app.ndb.selectStoryRevisions(r.Context(), params.StoryID)
+	if err != nil {
+		return errors.Wrap(err, "selectStoryRevisions")
+	}
+
+	item, err := app.ndb.selectStoryItem(r.Context(), params.StoryID)
+	if err != nil {
+		return errors.Wrap(err, "selectStoryItem")
+	}
+
 	d := StatsPageData{
 		StatsPageParams:     params,
 		EstimatedUpvoteRate: 1.0,
 		StoryTemplateData:   storyTemplate,
 		StatsData:           stats,
+		Events:              events,
+		Resubmissions:       resubmissions,
+		Revisions:           revisions,
+		Item:                item,
 	}
 
 	err = templates.ExecuteTemplate(w, "stats.html.tmpl", d)
@@ -157,6 +210,7 @@ func (app app) loadStoryAndStats(ctx context.Context, storyID int, modelParams O
 			RanksPlotDataJSON:   template.JS(string(ranksJson)),
 			UpvotesPlotDataJSON: template.JS(string(upvotesJson)),
 			MaxSampleTime:       archiveData.MaxSampleTime,
+			Penalty:             archiveData.Penalty,
 		}
 
 		return s, stats, nil
@@ -168,6 +222,11 @@ func (app app) loadStoryAndStats(ctx context.Context, storyID int, modelParams O
 		return Story{}, StatsData{}, errors.Wrap(err, "maxSampleTime")
 	}
 
+	penalty, err := latestPenalty(ctx, ndb, storyID)
+	if err != nil {
+		return Story{}, StatsData{}, errors.Wrap(err, "latestPenalty")
+	}
+
 	ranks, err := rankDatapoints(ctx, ndb, storyID)
 	if err != nil {
 		return Story{}, StatsData{}, errors.Wrap(err, "rankDatapoints")
@@ -192,6 +251,7 @@ func (app app) loadStoryAndStats(ctx context.Context, storyID int, modelParams O
 		RanksPlotDataJSON:   template.JS(string(ranksJson)),
 		UpvotesPlotDataJSON: template.JS(string(upvotesJson)),
 		MaxSampleTime:       maxSampleTime,
+		Penalty:             penalty,
 	}
 
 	return s, stats, nil
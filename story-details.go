@@ -13,25 +13,28 @@ import (
 )
 
 type Story struct {
-	ID                        int
-	By                        string
-	Title                     string
-	URL                       string
-	SubmissionTime            int64
-	OriginalSubmissionTime    int64
-	AgeApprox                 int64
-	Score                     int
-	Comments                  int
-	CumulativeUpvotes         int
-	CumulativeExpectedUpvotes float64
-	UpvoteRate                float64
-	TopRank                   sql.NullInt32
-	QNRank                    sql.NullInt32
-	RawRank                   sql.NullInt32
-	Job                       bool
-	Flagged                   bool
-	Dupe                      bool
-	Archived                  bool
+	ID                         int
+	By                         string
+	Title                      string
+	URL                        string
+	SubmissionTime             int64
+	OriginalSubmissionTime     int64
+	AgeApprox                  int64
+	Score                      int
+	Comments                   int
+	CumulativeUpvotes          int
+	CumulativeExpectedUpvotes  float64
+	UpvoteRate                 float64
+	CumulativeComments         int
+	CumulativeExpectedComments float64
+	CommentRate                float64
+	TopRank                    sql.NullInt32
+	QNRank                     sql.NullInt32
+	RawRank                    sql.NullInt32
+	Job                        bool
+	Flagged                    bool
+	Dupe                       bool
+	Archived                   bool
 }
 
 // PageTemplateData contains the common template data for all pages
@@ -95,6 +98,16 @@ func (p PageTemplateData) IsResubmissionsPage() bool {
 	return p.Ranking == "resubmissions"
 }
 
+func (p PageTemplateData) IsDiscussionPage() bool {
+	return p.Ranking == "discussion"
+}
+
+// IsFormulaPage is true for the pages of ranking formulas (see rankingFormula)
+func (p PageTemplateData) IsFormulaPage() bool {
+	_, ok := formulaName(p.Ranking)
+	return ok
+}
+
 // Default implementations for non-ranking based pages
 func (p PageTemplateData) IsAboutPage() bool {
 	return false
@@ -112,8 +125,24 @@ func (p PageTemplateData) IsStatsPage() bool {
 	return false
 }
 
+func (p PageTemplateData) IsEventsPage() bool {
+	return false
+}
+
+func (p PageTemplateData) IsCrawlsPage() bool {
+	return false
+}
+
+func (p PageTemplateData) IsParseHealthPage() bool {
+	return false
+}
+
+func (p PageTemplateData) IsSearchPage() bool {
+	return false
+}
+
 func (p PageTemplateData) IsAlternativeFrontPage() bool {
-	return p.IsHNTopPage() || p.IsRawPage() || p.IsPenaltiesPage() || p.IsBoostsPage() || p.IsResubmissionsPage() || p.IsFairPage() || p.IsUpvoteratePage() || p.IsBestUpvoteratePage() || p.IsNewPage() || p.IsBestPage() || p.IsAskPage() || p.IsShowPage()
+	return p.IsHNTopPage() || p.IsRawPage() || p.IsPenaltiesPage() || p.IsBoostsPage() || p.IsResubmissionsPage() || p.IsDiscussionPage() || p.IsFairPage() || p.IsUpvoteratePage() || p.IsBestUpvoteratePage() || p.IsNewPage() || p.IsBestPage() || p.IsAskPage() || p.IsShowPage() || p.IsFormulaPage()
 }
 
 func (s Story) AgeString() string {
@@ -190,7 +219,10 @@ func (s Story) Domain() string {
 	}
 	if domain == "twitter.com" || domain == "github.com" {
 		// keep first part of path
-		return domain + "/" + strings.Split(u.Path, "/")[1]
+		if account := strings.Split(u.Path, "/"); len(account) > 1 && account[1] != "" {
+			return domain + "/" + account[1]
+		}
+		return domain
 	}
 
 	if domain == "substack.com" || domain == "notion.site" || domain == "dreamhosters.com" {
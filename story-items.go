@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/johnwarden/hn"
+	"github.com/pkg/errors"
+)
+
+// A StoryItem holds the details of a story that only the Hacker News API
+// provides: the item type (story, poll or job), the text of self-posts such
+// as Ask HN and Show HN, the options of polls, and the number of direct
+// replies (kids).
+type StoryItem struct {
+	ID          int
+	Type        string
+	Text        string // HTML, as returned by the API
+	Kids        int
+	PollOptions []PollOption
+	// sampleTime of the crawl that fetched the item
+	Updated int64
+}
+
+type PollOption struct {
+	Text  string `json:"text"`
+	Score int    `json:"score"`
+}
+
+const (
+	// Items of stories that are still being crawled are fetched again after
+	// this many seconds, to pick up edits of the text, new replies and poll
+	// votes.
+	storyItemsRefreshInterval = 10 * 60
+
+	// the maximum number of items fetched in one crawl, in addition to the
+	// items of stories that were fetched from the API anyway
+	maxStoryItemsPerCrawl = 30
+)
+
+func newStoryItem(item hn.Item, pollOptions []hn.Item, sampleTime int64) StoryItem {
+	s := StoryItem{
+		ID:      item.ID,
+		Type:    item.Type,
+		Text:    item.Text,
+		Kids:    len(item.Kids),
+		Updated: sampleTime,
+	}
+	for _, o := range pollOptions {
+		s.PollOptions = append(s.PollOptions, PollOption{html.UnescapeString(o.Text), o.Score})
+	}
+	return s
+}
+
+func (i StoryItem) IsPoll() bool {
+	return i.Type == "poll"
+}
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// Paragraphs returns the text as plain-text paragraphs. The API returns
+// the text as HTML with paragraphs separated by <p>, and links and
+// formatting, which are dropped.
+func (i StoryItem) Paragraphs() []string {
+	var paragraphs []string
+	for _, p := range strings.Split(i.Text, "<p>") {
+		p = strings.TrimSpace(html.UnescapeString(htmlTagRegexp.ReplaceAllString(p, "")))
+		if p != "" {
+			paragraphs = append(paragraphs, p)
+		}
+	}
+	return paragraphs
+}
+
+// crawlStoryItems stores the items of stories that were fetched from the
+// API during the crawl, and fetches the items of other stories in ids that
+// haven't been fetched in the last storyItemsRefreshInterval. Failures to
+// fetch items are logged but don't fail the crawl.
+func (app app) crawlStoryItems(ctx context.Context, tx *sql.Tx, sampleTime int64, ids []int, apiItems []hn.Item) (int, error) {
+	fetched := make(map[int]bool)
+	items := make([]hn.Item, 0, len(apiItems)+maxStoryItemsPerCrawl)
+	for _, item := range apiItems {
+		fetched[item.ID] = true
+		items = append(items, item)
+	}
+
+	due, err := app.ndb.selectStoryItemsDue(tx, sampleTime, ids)
+	if err != nil {
+		return 0, errors.Wrap(err, "selectStoryItemsDue")
+	}
+	var toFetch []int
+	for _, id := range due {
+		if !fetched[id] && len(toFetch) < maxStoryItemsPerCrawl {
+			toFetch = append(toFetch, id)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	getItems := func(ids []int) []hn.Item {
+		if len(ids) == 0 {
+			return nil
+		}
+		result, err := app.rankSource.GetItems(ctx, ids, maxGoroutines)
+		if err != nil {
+			LogErrorf(app.logger, "Failed to fetch story items: %v", err)
+			crawlErrorsTotal.Inc()
+		}
+		return result
+	}
+
+	items = append(items, getItems(toFetch)...)
+
+	var n int
+	for _, item := range items {
+		// items that failed to download are left empty
+		if item.ID == 0 {
+			continue
+		}
+
+		var pollOptions []hn.Item
+		if item.Type == "poll" {
+			pollOptions = getItems(item.Parts)
+		}
+
+		if err := app.ndb.upsertStoryItem(tx, newStoryItem(item, pollOptions, sampleTime)); err != nil {
+			return n, errors.Wrap(err, "upsertStoryItem")
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// selectStoryItemsDue returns the ids of stories whose items have never
+// been fetched, followed by those that were fetched longest ago, up to
+// storyItemsRefreshInterval before sampleTime.
+func (ndb newsDatabase) selectStoryItemsDue(tx *sql.Tx, sampleTime int64, ids []int) ([]int, error) {
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshaling ids")
+	}
+
+	rows, err := tx.Query(`
+		select ids.value
+		from json_each(?) ids
+		left join story_items on story_items.id = ids.value
+		where story_items.updated is null or story_items.updated <= ?
+		order by story_items.updated nulls first
+	`, string(idsJSON), sampleTime-storyItemsRefreshInterval)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting story items due")
+	}
+	defer rows.Close()
+
+	var due []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		due = append(due, id)
+	}
+
+	return due, rows.Err()
+}
+
+func (ndb newsDatabase) upsertStoryItem(tx *sql.Tx, i StoryItem) error {
+	var pollOptions sql.NullString
+	if i.IsPoll() {
+		b, err := json.Marshal(i.PollOptions)
+		if err != nil {
+			return errors.Wrap(err, "marshaling poll options")
+		}
+		pollOptions = sql.NullString{String: string(b), Valid: true}
+	}
+
+	_, err := tx.Exec(`
+		insert into story_items (id, type, text, kids, pollOptions, updated) values (?, ?, ?, ?, ?, ?)
+		on conflict (id) do update set
+			type = excluded.type
+			, text = excluded.text
+			, kids = excluded.kids
+			, pollOptions = excluded.pollOptions
+			, updated = excluded.updated
+	`, i.ID, i.Type, i.Text, i.Kids, pollOptions, i.Updated)
+	return err
+}
+
+// selectStoryItem returns the item of a story, or an empty StoryItem if it
+// hasn't been fetched.
+func (ndb newsDatabase) selectStoryItem(ctx context.Context, id int) (StoryItem, error) {
+	var i StoryItem
+	var pollOptions sql.NullString
+
+	err := ndb.db.QueryRowContext(ctx, `
+		select id, type, text, kids, pollOptions, updated from story_items where id = ?
+	`, id).Scan(&i.ID, &i.Type, &i.Text, &i.Kids, &pollOptions, &i.Updated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return StoryItem{}, nil
+	}
+	if err != nil {
+		return i, errors.Wrap(err, "selecting story item")
+	}
+
+	if pollOptions.Valid {
+		err = json.Unmarshal([]byte(pollOptions.String), &i.PollOptions)
+	}
+	return i, errors.Wrap(err, "parsing poll options")
+}
+
+// apiStoryItem is the JSON representation of a story item search result.
+// Snippet is an excerpt of the plain text around the first match.
+type apiStoryItem struct {
+	ID             int    `json:"id"`
+	Title          string `json:"title"`
+	By             string `json:"by"`
+	SubmissionTime int64  `json:"submissionTime"`
+	Type           string `json:"type"`
+	Kids           int    `json:"kids"`
+	Snippet        string `json:"snippet"`
+}
+
+type StoryItemSearchParams struct {
+	Query string `schema:"q,required"`
+	Type  string `schema:"type"`
+	Limit int    `schema:"limit"`
+}
+
+const maxStoryItemSearchResults = 100
+
+func (p StoryItemSearchParams) limit() int {
+	if p.Limit <= 0 {
+		return 30
+	}
+	return min(p.Limit, maxStoryItemSearchResults)
+}
+
+// searchStoryItems searches the text of story items. With FTS5 (see
+// storyItemsSearchSQL), results are ordered by relevance, otherwise by
+// submission time.
+func (ndb newsDatabase) searchStoryItems(ctx context.Context, p StoryItemSearchParams) ([]apiStoryItem, error) {
+	match := p.Query
+	if fts5Enabled {
+		match = ftsQuery(p.Query)
+	}
+
+	rows, err := ndb.db.QueryContext(ctx, storyItemsSearchSQL, match, p.Type, p.Type, p.limit())
+	if err != nil {
+		return nil, errors.Wrap(err, "searching story items")
+	}
+	defer rows.Close()
+
+	results := []apiStoryItem{}
+	for rows.Next() {
+		var r apiStoryItem
+		var i StoryItem
+		if err := rows.Scan(&r.ID, &r.Title, &r.By, &r.SubmissionTime, &r.Type, &r.Kids, &i.Text); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		r.Snippet = snippet(strings.Join(i.Paragraphs(), " "), p.Query)
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// ftsQuery turns a search query into an FTS5 query that matches all words
+// in the query, quoting each word so that FTS5 syntax in the query isn't
+// interpreted.
+func ftsQuery(q string) string {
+	words := strings.Fields(q)
+	for i, w := range words {
+		words[i] = `"` + strings.ReplaceAll(w, `"`, `""`) + `"`
+	}
+	return strings.Join(words, " ")
+}
+
+const snippetLength = 200
+
+// snippet returns about snippetLength characters of text around the first
+// word of query.
+func snippet(text string, query string) string {
+	runes := []rune(text)
+	if len(runes) <= snippetLength {
+		return text
+	}
+
+	start := 0
+	if words := strings.Fields(query); len(words) > 0 {
+		if i := strings.Index(strings.ToLower(text), strings.ToLower(words[0])); i >= 0 {
+			start = max(len([]rune(text[:i]))-snippetLength/4, 0)
+		}
+	}
+	end := min(start+snippetLength, len(runes))
+
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
+
+// storyItemsSearchHandler serves the results of searchStoryItems as JSON.
+func (app app) storyItemsSearchHandler() func(http.ResponseWriter, *http.Request, StoryItemSearchParams) error {
+	return func(w http.ResponseWriter, r *http.Request, p StoryItemSearchParams) error {
+		results, err := app.ndb.searchStoryItems(r.Context(), p)
+		if err != nil {
+			return errors.Wrap(err, "searchStoryItems")
+		}
+
+		b, err := json.Marshal(results)
+		if err != nil {
+			return errors.Wrap(err, "marshaling search results JSON")
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		_, err = w.Write(b)
+		return errors.Wrap(err, "writing HTTP response")
+	}
+}
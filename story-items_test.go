@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFTSQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want string
+	}{
+		{q: "", want: ""},
+		{q: "rust", want: `"rust"`},
+		{q: "  rust   compiler ", want: `"rust" "compiler"`},
+		// FTS5 syntax is matched literally
+		{q: "rust OR go", want: `"rust" "OR" "go"`},
+		{q: "title:rust*", want: `"title:rust*"`},
+		{q: `"quoted`, want: `"""quoted"`},
+	}
+
+	for _, tt := range tests {
+		if got := ftsQuery(tt.q); got != tt.want {
+			t.Errorf("ftsQuery(%q) = %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("a ", 200) + "needle " + strings.Repeat("b ", 200)
+
+	tests := []struct {
+		name       string
+		text       string
+		query      string
+		wantPrefix string
+		wantSuffix string
+		contains   string
+	}{
+		{name: "short text", text: "short text", query: "text", contains: "short text"},
+		{name: "match in the middle", text: long, query: "Needle", wantPrefix: "…", wantSuffix: "…", contains: "needle"},
+		{name: "no match", text: long, query: "missing", wantSuffix: "…", contains: "a a a"},
+	}
+
+	for _, tt := range tests {
+		got := snippet(tt.text, tt.query)
+		if !strings.HasPrefix(got, tt.wantPrefix) || !strings.HasSuffix(got, tt.wantSuffix) || !strings.Contains(got, tt.contains) {
+			t.Errorf("%s: snippet = %q", tt.name, got)
+		}
+		if tt.wantPrefix == "" && strings.HasPrefix(got, "…") {
+			t.Errorf("%s: snippet = %q, want no leading ellipsis", tt.name, got)
+		}
+		if n := len([]rune(strings.Trim(got, "…"))); n > snippetLength {
+			t.Errorf("%s: snippet is %d characters long, want at most %d", tt.name, n, snippetLength)
+		}
+	}
+}
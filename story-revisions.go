@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A StoryRevision is a change to a story noticed by the crawler: an edit of
+// its title or URL, or a change of its flagged, dupe or job status. The time
+// of a revision is the sampleTime of the first crawl that saw it.
+type StoryRevision struct {
+	StoryID    int
+	SampleTime int64
+	Field      string
+	OldValue   string
+	NewValue   string
+}
+
+func (r StoryRevision) SampleTimeISOString() string {
+	return time.Unix(r.SampleTime, 0).UTC().Format("2006-01-02T15:04")
+}
+
+// IsStatus is true for changes of boolean fields, which are shown as
+// e.g. "flagged" or "no longer flagged" instead of old and new values.
+func (r StoryRevision) IsStatus() bool {
+	return r.Field == "flagged" || r.Field == "dupe" || r.Field == "job"
+}
+
+// Label is a short description of the revision, for annotating charts.
+func (r StoryRevision) Label() string {
+	switch {
+	case r.IsStatus() && r.NewValue == "true":
+		return r.Field
+	case r.IsStatus():
+		return "no longer " + r.Field
+	default:
+		return r.Field + " edited"
+	}
+}
+
+// insertStoryRevisions records the changes of story since the last crawl
+// that saw it, as loaded by selectLastSeenData. It must be called before
+// insertOrReplaceStory overwrites the story.
+//
+// Titles and URLs are only compared when the story was scraped, because the
+// API lags behind edits, and the flagged and dupe status are only known for
+// scraped stories.
+func (ndb newsDatabase) insertStoryRevisions(tx *sql.Tx, sampleTime int64, story ScrapedStory, last lastSeenData) (int, error) {
+	var revisions []StoryRevision
+	addRevision := func(field, oldValue, newValue string) {
+		if oldValue != newValue {
+			revisions = append(revisions, StoryRevision{story.ID, sampleTime, field, oldValue, newValue})
+		}
+	}
+
+	if story.FieldSources["Title"] != "api" {
+		addRevision("title", last.title, story.Title)
+	}
+	if story.FieldSources["URL"] != "api" {
+		addRevision("url", last.url, story.URL)
+	}
+	addRevision("job", strconv.FormatBool(last.job), strconv.FormatBool(story.Job))
+	if story.FieldSources["Flagged"] != "api" {
+		addRevision("flagged", strconv.FormatBool(last.flagged), strconv.FormatBool(story.Flagged))
+	}
+	if story.FieldSources["Dupe"] != "api" {
+		addRevision("dupe", strconv.FormatBool(last.dupe), strconv.FormatBool(story.Dupe))
+	}
+
+	for _, r := range revisions {
+		_, err := tx.Exec(`
+			insert into story_revisions (id, sampleTime, field, oldValue, newValue) values (?, ?, ?, ?, ?)
+			on conflict do nothing
+		`, r.StoryID, r.SampleTime, r.Field, r.OldValue, r.NewValue)
+		if err != nil {
+			return 0, errors.Wrap(err, "inserting story revision")
+		}
+	}
+
+	return len(revisions), nil
+}
+
+// selectStoryRevisions returns the revisions of a story, oldest first.
+func (ndb newsDatabase) selectStoryRevisions(ctx context.Context, storyID int) ([]StoryRevision, error) {
+	rows, err := ndb.db.QueryContext(ctx, `
+		select id, sampleTime, field, oldValue, newValue
+		from story_revisions
+		where id = ?
+		order by sampleTime, field
+	`, storyID)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting story revisions")
+	}
+	defer rows.Close()
+
+	var revisions []StoryRevision
+	for rows.Next() {
+		var r StoryRevision
+		if err := rows.Scan(&r.StoryID, &r.SampleTime, &r.Field, &r.OldValue, &r.NewValue); err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		revisions = append(revisions, r)
+	}
+
+	return revisions, rows.Err()
+}
+
+// RevisionsJSON lists the sampleTime and label of the story's revisions,
+// for annotating the ranks plot.
+func (s StatsPageData) RevisionsJSON() template.JS {
+	revisions := make([][]any, len(s.Revisions))
+	for i, r := range s.Revisions {
+		revisions[i] = []any{r.SampleTime, r.Label()}
+	}
+	b, _ := json.Marshal(revisions)
+	return template.JS(b)
+}
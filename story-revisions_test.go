@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestStoryRevisionLabel(t *testing.T) {
+	tests := []struct {
+		field, oldValue, newValue string
+		want                      string
+	}{
+		{"title", "Old title", "New title", "title edited"},
+		{"url", "https://example.com/a", "https://example.com/b", "url edited"},
+		{"flagged", "false", "true", "flagged"},
+		{"flagged", "true", "false", "no longer flagged"},
+		{"dupe", "false", "true", "dupe"},
+		{"job", "true", "false", "no longer job"},
+	}
+
+	for _, tt := range tests {
+		r := StoryRevision{Field: tt.field, OldValue: tt.oldValue, NewValue: tt.newValue}
+		if got := r.Label(); got != tt.want {
+			t.Errorf("Label() of %s %q -> %q = %q, want %q", tt.field, tt.oldValue, tt.newValue, got, tt.want)
+		}
+	}
+}
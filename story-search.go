@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/johnwarden/httperror"
+	"github.com/pkg/errors"
+)
+
+// Stories can be searched by their title, URL, domain and author. With
+// FTS5, the stories_fts index is searched (see storiesMatchSQL), and
+// results are ordered by relevance, otherwise stories are searched with
+// like and ordered by submission time. Without a query, all stories that
+// match the filters are listed, most recent first.
+
+type StorySearchParams struct {
+	Query string `schema:"q"`
+	// submission dates, YYYY-MM-DD (UTC), both inclusive
+	From   string `schema:"from"`
+	To     string `schema:"to"`
+	Domain string `schema:"domain"`
+	// the upvote rate of the latest datapoint of the story
+	MinUpvoteRate float64 `schema:"minUpvoteRate"`
+	// only stories ranked on the Hacker News front page (top 30)
+	FrontPage bool `schema:"frontPage"`
+	Limit     int  `schema:"limit"`
+}
+
+const (
+	maxStorySearchResults = 100
+
+	storySearchDateFormat = "2006-01-02"
+)
+
+func (p StorySearchParams) limit() int {
+	if p.Limit <= 0 {
+		return 30
+	}
+	return min(p.Limit, maxStorySearchResults)
+}
+
+// IsEmpty is true if neither a query nor any filter is given.
+func (p StorySearchParams) IsEmpty() bool {
+	return p == StorySearchParams{Limit: p.Limit}
+}
+
+// timeRange returns the submission time range selected by From and To, as
+// unix timestamps, with To exclusive. The range is unbounded on either side
+// if not given.
+func (p StorySearchParams) timeRange() (int64, int64, error) {
+	var from, to int64 = 0, math.MaxInt64
+	if p.From != "" {
+		t, err := time.Parse(storySearchDateFormat, p.From)
+		if err != nil {
+			return 0, 0, httperror.New(http.StatusBadRequest, fmt.Sprintf("Invalid from date %q, expected YYYY-MM-DD", p.From))
+		}
+		from = t.Unix()
+	}
+	if p.To != "" {
+		t, err := time.Parse(storySearchDateFormat, p.To)
+		if err != nil {
+			return 0, 0, httperror.New(http.StatusBadRequest, fmt.Sprintf("Invalid to date %q, expected YYYY-MM-DD", p.To))
+		}
+		to = t.AddDate(0, 0, 1).Unix()
+	}
+	return from, to, nil
+}
+
+// domain returns the Domain filter in the form returned by Story.Domain,
+// so that e.g. www.example.com matches stories from example.com.
+func (p StorySearchParams) domain() string {
+	if p.Domain == "" {
+		return ""
+	}
+	if d := (Story{URL: "https://" + p.Domain}).Domain(); d != "" {
+		return d
+	}
+	return p.Domain
+}
+
+const storySearchSQL = `
+	select
+		s.id
+		, s.by
+		, s.title
+		, s.url
+		, ifnull(latest.submissionTime, s.timestamp)
+		, s.timestamp
+		, ifnull(unixepoch() - latest.sampleTime + coalesce(latest.ageApprox, latest.sampleTime - latest.submissionTime), unixepoch() - s.timestamp)
+		, ifnull(latest.score, 0)
+		, ifnull(latest.descendants, 0)
+		, ifnull(latest.cumulativeUpvotes, 0)
+		, ifnull(latest.cumulativeExpectedUpvotes, 0)
+		, ifnull(latest.cumulativeComments, 0)
+		, ifnull(latest.cumulativeExpectedComments, 0)
+		, latest.topRank
+		, latest.qnRank
+		, latest.rawRank
+		, ifnull(latest.flagged, false)
+		, ifnull(latest.dupe, false)
+		, s.job
+		, s.archived
+	from %s
+	left join dataset latest on latest.id = s.id
+	and latest.sampleTime = (select max(sampleTime) from dataset where id = s.id)
+	where s.timestamp >= :from and s.timestamp < :to
+	and (:domain = '' or s.domain = :domain)
+	and (:minUpvoteRate = 0 or (latest.cumulativeUpvotes + :priorWeight)/((1-exp(-:fatigueFactor*latest.cumulativeExpectedUpvotes))/:fatigueFactor + :priorWeight) >= :minUpvoteRate)
+	and (not :frontPage or exists (select 1 from dataset f where f.id = s.id and f.topRank <= 30))
+	order by %s
+	limit :limit
+`
+
+// Without a query, stories are listed from the stories_timestamp index,
+// most recent first, so only as many stories are read as are needed to fill
+// the limit.
+const (
+	storySearchMatchesFrom = "(%s) matches join stories s on s.id = matches.id"
+	storySearchAllFrom     = "stories s indexed by stories_timestamp"
+
+	storySearchMatchesOrder = "matches.rank, s.timestamp desc"
+	storySearchAllOrder     = "s.timestamp desc"
+)
+
+// searchStories returns the stories that match the query and filters, with
+// the stats of their latest datapoint.
+func (ndb newsDatabase) searchStories(ctx context.Context, p StorySearchParams) ([]Story, error) {
+	from, to, err := p.timeRange()
+	if err != nil {
+		return nil, err
+	}
+
+	fromSQL, orderSQL := storySearchAllFrom, storySearchAllOrder
+	query := p.Query
+	if query != "" {
+		fromSQL, orderSQL = fmt.Sprintf(storySearchMatchesFrom, storiesMatchSQL), storySearchMatchesOrder
+		if fts5Enabled {
+			query = ftsQuery(query)
+		}
+	}
+
+	modelParams := defaultModelParams
+	rows, err := ndb.db.QueryContext(ctx, fmt.Sprintf(storySearchSQL, fromSQL, orderSQL),
+		sql.Named("query", query),
+		sql.Named("from", from),
+		sql.Named("to", to),
+		sql.Named("domain", p.domain()),
+		sql.Named("minUpvoteRate", p.MinUpvoteRate),
+		sql.Named("priorWeight", modelParams.PriorWeight),
+		sql.Named("fatigueFactor", modelParams.FatigueFactor),
+		sql.Named("frontPage", p.FrontPage),
+		sql.Named("limit", p.limit()),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "searching stories")
+	}
+	defer rows.Close()
+
+	stories := []Story{}
+	for rows.Next() {
+		var s Story
+		err := rows.Scan(
+			&s.ID, &s.By, &s.Title, &s.URL, &s.SubmissionTime, &s.OriginalSubmissionTime,
+			&s.AgeApprox, &s.Score, &s.Comments, &s.CumulativeUpvotes, &s.CumulativeExpectedUpvotes,
+			&s.CumulativeComments, &s.CumulativeExpectedComments,
+			&s.TopRank, &s.QNRank, &s.RawRank, &s.Flagged, &s.Dupe, &s.Job, &s.Archived,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "rows.Scan")
+		}
+		s.UpvoteRate = modelParams.upvoteRate(s.CumulativeUpvotes, s.CumulativeExpectedUpvotes)
+		s.CommentRate = modelParams.commentRate(s.CumulativeComments, s.CumulativeExpectedComments)
+		stories = append(stories, s)
+	}
+
+	return stories, rows.Err()
+}
+
+// storySearchAPIHandler serves the results of searchStories as JSON.
+func (app app) storySearchAPIHandler() func(http.ResponseWriter, *http.Request, StorySearchParams) error {
+	return func(w http.ResponseWriter, r *http.Request, p StorySearchParams) error {
+		stories, err := app.ndb.searchStories(r.Context(), p)
+		if err != nil {
+			return errors.Wrap(err, "searchStories")
+		}
+
+		results := make([]apiStory, len(stories))
+		for i, s := range stories {
+			results[i] = newAPIStory(s)
+		}
+
+		b, err := json.Marshal(results)
+		if err != nil {
+			return errors.Wrap(err, "marshaling search results JSON")
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		_, err = w.Write(b)
+		return errors.Wrap(err, "writing HTTP response")
+	}
+}
+
+type SearchPageData struct {
+	PageTemplateData
+	StorySearchParams
+	Stories []StoryTemplateData
+}
+
+func (d SearchPageData) IsSearchPage() bool {
+	return true
+}
+
+// APIURL is the URL of the JSON API with the same parameters.
+func (d SearchPageData) APIURL() string {
+	v := url.Values{}
+	set := func(key, value string) {
+		if value != "" {
+			v.Set(key, value)
+		}
+	}
+	set("q", d.Query)
+	set("from", d.From)
+	set("to", d.To)
+	set("domain", d.Domain)
+	if d.MinUpvoteRate != 0 {
+		v.Set("minUpvoteRate", strconv.FormatFloat(d.MinUpvoteRate, 'f', -1, 64))
+	}
+	if d.FrontPage {
+		v.Set("frontPage", "true")
+	}
+	if d.Limit != 0 {
+		v.Set("limit", strconv.Itoa(d.Limit))
+	}
+	return "/api/v1/search?" + v.Encode()
+}
+
+func (app app) searchHandler() func(http.ResponseWriter, *http.Request, StorySearchParams) error {
+	return func(w http.ResponseWriter, r *http.Request, p StorySearchParams) error {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		d := SearchPageData{
+			PageTemplateData:  PageTemplateData{UserID: app.getUserID(r)},
+			StorySearchParams: p,
+		}
+
+		if !p.IsEmpty() {
+			stories, err := app.ndb.searchStories(r.Context(), p)
+			if err != nil {
+				return errors.Wrap(err, "searchStories")
+			}
+			for _, s := range stories {
+				d.Stories = append(d.Stories, StoryTemplateData{Story: s, PageTemplateData: d.PageTemplateData})
+			}
+		}
+
+		err := templates.ExecuteTemplate(w, "search.html.tmpl", d)
+		return errors.Wrap(err, "executing search page template")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestStorySearchParamsTimeRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		wantFrom int64
+		wantTo   int64
+		wantErr  bool
+	}{
+		{name: "unbounded", wantFrom: 0, wantTo: math.MaxInt64},
+		{name: "from", from: "2024-01-01", wantFrom: 1704067200, wantTo: math.MaxInt64},
+		// to is inclusive
+		{name: "to", to: "2024-01-01", wantFrom: 0, wantTo: 1704067200 + 24*3600},
+		{name: "single day", from: "2024-01-01", to: "2024-01-01", wantFrom: 1704067200, wantTo: 1704067200 + 24*3600},
+		{name: "bad from", from: "01/01/2024", wantErr: true},
+		{name: "bad to", to: "2024-01-01T00:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		from, to, err := StorySearchParams{From: tt.from, To: tt.to}.timeRange()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: timeRange returned no error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: timeRange returned error: %v", tt.name, err)
+			continue
+		}
+		if from != tt.wantFrom || to != tt.wantTo {
+			t.Errorf("%s: timeRange = %d, %d, want %d, %d", tt.name, from, to, tt.wantFrom, tt.wantTo)
+		}
+	}
+}
+
+func TestStorySearchParams(t *testing.T) {
+	tests := []struct {
+		p          StorySearchParams
+		wantLimit  int
+		wantDomain string
+		wantEmpty  bool
+	}{
+		{p: StorySearchParams{}, wantLimit: 30, wantEmpty: true},
+		{p: StorySearchParams{Limit: 50}, wantLimit: 50, wantEmpty: true},
+		{p: StorySearchParams{Query: "rust", Limit: 1000}, wantLimit: maxStorySearchResults},
+		{p: StorySearchParams{Domain: "www.example.com"}, wantLimit: 30, wantDomain: "example.com"},
+		{p: StorySearchParams{Domain: "github.com/user/repo"}, wantLimit: 30, wantDomain: "github.com/user"},
+		{p: StorySearchParams{FrontPage: true}, wantLimit: 30},
+	}
+
+	for _, tt := range tests {
+		if got := tt.p.limit(); got != tt.wantLimit {
+			t.Errorf("%+v.limit() = %d, want %d", tt.p, got, tt.wantLimit)
+		}
+		if got := tt.p.domain(); got != tt.wantDomain {
+			t.Errorf("%+v.domain() = %q, want %q", tt.p, got, tt.wantDomain)
+		}
+		if got := tt.p.IsEmpty(); got != tt.wantEmpty {
+			t.Errorf("%+v.IsEmpty() = %v, want %v", tt.p, got, tt.wantEmpty)
+		}
+	}
+}
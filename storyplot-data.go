@@ -17,6 +17,20 @@ func maxSampleTime(ctx context.Context, ndb newsDatabase, storyID int) (int, err
 	return n, errors.Wrap(err, "QueryRow count: select max(sampleTime)")
 }
 
+// latestPenalty returns the domain penalty applied to the story in the
+// latest crawl.
+func latestPenalty(ctx context.Context, ndb newsDatabase, storyID int) (float64, error) {
+	var penalty float64
+	err := ndb.db.QueryRowContext(ctx, `
+			select penalty from dataset
+			where id = ?
+			order by sampleTime desc
+			limit 1
+		`, storyID).Scan(&penalty)
+
+	return penalty, errors.Wrap(err, "QueryRow: select penalty")
+}
+
 func rankDatapoints(ctx context.Context, ndb newsDatabase, storyID int) ([][]any, error) {
 	var n int
 	if err := ndb.db.QueryRowContext(ctx, "select count(*) from dataset where id = ?", storyID).Scan(&n); err != nil {
@@ -74,6 +88,10 @@ func rankDatapoints(ctx context.Context, ndb newsDatabase, storyID int) ([][]any
 	return ranks, errors.Wrap(err, "rows.Err")
 }
 
+// upvotesDatapoints returns a row [sampleTime, upvotes, expectedUpvotes,
+// upvoteRate, comments, expectedComments, commentRate] for every datapoint
+// of a story. Archives store the same rows, so stories archived before
+// comments were tracked lack the last three columns.
 func upvotesDatapoints(ctx context.Context, ndb newsDatabase, storyID int, modelParams ModelParams) ([][]any, error) {
 	var n int
 	if err := ndb.db.QueryRowContext(ctx, "select count(*) from dataset where id = ?", storyID).Scan(&n); err != nil {
@@ -91,7 +109,7 @@ func upvotesDatapoints(ctx context.Context, ndb newsDatabase, storyID int, model
 
 	upvotesData := make([][]any, n)
 
-	rows, err := ndb.db.QueryContext(ctx, `select sampleTime, cumulativeUpvotes, cumulativeExpectedUpvotes
+	rows, err := ndb.db.QueryContext(ctx, `select sampleTime, cumulativeUpvotes, cumulativeExpectedUpvotes, cumulativeComments, cumulativeExpectedComments
  	 from dataset where id = ?`, storyID)
 	if err != nil {
 		return nil, errors.Wrap(err, "Query: select upvotes")
@@ -103,8 +121,10 @@ func upvotesDatapoints(ctx context.Context, ndb newsDatabase, storyID int, model
 		var sampleTime int64
 		var upvotes int
 		var expectedUpvotes float64
+		var comments int
+		var expectedComments float64
 
-		err = rows.Scan(&sampleTime, &upvotes, &expectedUpvotes)
+		err = rows.Scan(&sampleTime, &upvotes, &expectedUpvotes, &comments, &expectedComments)
 
 		if err != nil {
 			return nil, errors.Wrap(err, "rows.Scan")
@@ -115,6 +135,9 @@ func upvotesDatapoints(ctx context.Context, ndb newsDatabase, storyID int, model
 			int32(upvotes),
 			expectedUpvotes,
 			modelParams.upvoteRate(upvotes, expectedUpvotes),
+			int32(comments),
+			expectedComments,
+			modelParams.commentRate(comments, expectedComments),
 		}
 		i++
 	}
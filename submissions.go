@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/johnwarden/hn"
+	"github.com/pkg/errors"
+)
+
+// Stories only enter the dataset when they are ranked on one of the crawled
+// pages, so submissions that fall off the new page before they are crawled
+// are never seen. The submission tracker walks through every item ID up to
+// the API's maxitem, and records every story, job and poll in the
+// submissions table, with its submission time, author and domain, and the
+// sampleTime of the first crawl that ranked it, if any.
+//
+// The largest item ID checked is stored with each crawl (crawls.maxItem),
+// so the tracker continues where the last successful crawl left off. Most
+// items are comments, which are skipped.
+
+const (
+	// At most this many items are checked per crawl. When the tracker falls
+	// behind, e.g. after downtime, it catches up over several crawls.
+	maxSubmissionItemsPerCrawl = 1000
+)
+
+// trackSubmissions checks the items since the maxItem of the last crawl and
+// records the submissions among them. It returns the new maxItem and the
+// number of submissions recorded. Failures to fetch items are logged but
+// don't fail the crawl: items that fail are retried in the next crawl,
+// unless they are more than maxSubmissionItemsPerCrawl behind maxitem.
+func (app app) trackSubmissions(ctx context.Context, tx *sql.Tx, sampleTime int64) (int, int, error) {
+	var lastMaxItem int
+	if err := tx.QueryRowContext(ctx, "select ifnull(max(maxItem), 0) from crawls").Scan(&lastMaxItem); err != nil {
+		return 0, 0, errors.Wrap(err, "selecting last maxItem")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	maxItem, err := app.rankSource.MaxItem(ctx)
+	if err != nil {
+		LogErrorf(app.logger, "Failed to fetch maxitem: %v", err)
+		crawlErrorsTotal.Inc()
+		return lastMaxItem, 0, nil
+	}
+
+	// start tracking at the current maxitem
+	if lastMaxItem == 0 {
+		return maxItem, 0, nil
+	}
+
+	ids := make([]int, 0, min(maxItem-lastMaxItem, maxSubmissionItemsPerCrawl))
+	for id := lastMaxItem + 1; id <= maxItem && len(ids) < maxSubmissionItemsPerCrawl; id++ {
+		ids = append(ids, id)
+	}
+
+	items, err := app.rankSource.GetItems(ctx, ids, maxGoroutines)
+	if err != nil {
+		LogErrorf(app.logger, "Failed to fetch items for submission tracker: %v", err)
+		crawlErrorsTotal.Inc()
+	}
+
+	checked := lastMaxItem
+	var n int
+	for i, item := range items {
+		// items that failed to download are left empty
+		if item.ID == 0 {
+			if ids[i] > maxItem-maxSubmissionItemsPerCrawl {
+				break
+			}
+			submissionItemsSkippedTotal.Inc()
+			checked = ids[i]
+			continue
+		}
+		checked = item.ID
+
+		if !isSubmission(item) {
+			continue
+		}
+
+		if err := app.ndb.insertSubmission(tx, item); err != nil {
+			return lastMaxItem, n, errors.Wrap(err, "insertSubmission")
+		}
+		n++
+	}
+
+	trackedSubmissionsTotal.Add(n)
+
+	return checked, n, nil
+}
+
+// isSubmission reports whether an item is a submission: a story, job or
+// poll that wasn't deleted. Comments and poll options are also items.
+func isSubmission(item hn.Item) bool {
+	if item.Deleted {
+		return false
+	}
+	switch item.Type {
+	case "story", "job", "poll":
+		return true
+	default:
+		return false
+	}
+}
+
+func (ndb newsDatabase) insertSubmission(tx *sql.Tx, item hn.Item) error {
+	// the same domain as shown for stories, which is empty for text posts
+	domain := Story{URL: item.URL}.Domain()
+
+	// the story may have been ranked before the tracker got to it
+	_, err := tx.Exec(`
+		insert into submissions (id, submissionTime, by, domain, type, dead, firstRanked)
+		values (?, ?, ?, ?, ?, ?, (select min(sampleTime) from dataset where id = ?))
+		on conflict do nothing
+	`, item.ID, item.Timestamp, item.By, domain, item.Type, item.Dead, item.ID)
+	return err
+}
+
+// updateSubmissionsRanked sets the firstRanked time of the submissions
+// ranked in the crawl at sampleTime.
+func (ndb newsDatabase) updateSubmissionsRanked(tx *sql.Tx, sampleTime int64, storyRanks map[int]ranksArray) error {
+	ranked := make([]int, 0, len(storyRanks))
+	for id, ranks := range storyRanks {
+		if ranks != (ranksArray{}) {
+			ranked = append(ranked, id)
+		}
+	}
+
+	idsJSON, err := json.Marshal(ranked)
+	if err != nil {
+		return errors.Wrap(err, "marshaling ids")
+	}
+
+	_, err = tx.Exec(`
+		update submissions set firstRanked = ?
+		where id in (select value from json_each(?)) and firstRanked is null
+	`, sampleTime, string(idsJSON))
+	return errors.Wrap(err, "updating submissions")
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/johnwarden/hn"
+)
+
+func TestIsSubmission(t *testing.T) {
+	tests := []struct {
+		item hn.Item
+		want bool
+	}{
+		{item: hn.Item{Type: "story"}, want: true},
+		{item: hn.Item{Type: "job"}, want: true},
+		{item: hn.Item{Type: "poll"}, want: true},
+		{item: hn.Item{Type: "story", Dead: true}, want: true},
+		{item: hn.Item{Type: "story", Deleted: true}, want: false},
+		{item: hn.Item{Type: "comment"}, want: false},
+		{item: hn.Item{Type: "pollopt"}, want: false},
+		{item: hn.Item{}, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isSubmission(tt.item); got != tt.want {
+			t.Errorf("isSubmission(%+v) = %t, want %t", tt.item, got, tt.want)
+		}
+	}
+}
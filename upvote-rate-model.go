@@ -2,20 +2,37 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"math"
 )
 
 const (
-	nPages     = 3 // page 1 (rank 1-30), page 2, ...
+	nPages     = 3 // page 1 (rank 1-30), page 2, ... The builtin coefficients were fitted on the first nPages pages
 	nPageTypes = 5 // new, top, etc
 )
 
-type pageCoefficients = struct {
+type pageCoefficients struct {
 	pageTypeCoefficient float64
 	pageCoefficient     float64
 	rankCoefficient     float64
 }
 
+// UnmarshalJSON parses coefficients in the order they are listed below:
+// [pageTypeCoefficient, pageCoefficient, rankCoefficient].
+func (c *pageCoefficients) UnmarshalJSON(b []byte) error {
+	var cs [3]float64
+	if err := json.Unmarshal(b, &cs); err != nil {
+		return err
+	}
+	*c = pageCoefficients{cs[0], cs[1], cs[2]}
+	return nil
+}
+
+func (c pageCoefficients) MarshalJSON() ([]byte, error) {
+	return json.Marshal([3]float64{c.pageTypeCoefficient, c.pageCoefficient, c.rankCoefficient})
+}
+
 // These coefficients are the output of bayesian-quality-pagetype-rank.R
 // from the hacker-news-data repository.
 var (
@@ -37,6 +54,88 @@ var (
 	// priorWeight = 0.5
 )
 
+// pageTypeCoefficients holds the coefficients for each page type. In JSON
+// it is an object keyed by page type name:
+//
+//	{"top": [-2.886938, -3.316492, -0.5193376], "new": [...], ...}
+type pageTypeCoefficients [nPageTypes]pageCoefficients
+
+func (cs *pageTypeCoefficients) UnmarshalJSON(b []byte) error {
+	var byPageType map[string]pageCoefficients
+	if err := json.Unmarshal(b, &byPageType); err != nil {
+		return err
+	}
+
+	for pageType := top; pageType <= show; pageType++ {
+		c, ok := byPageType[pageTypes[pageType]]
+		if !ok {
+			return fmt.Errorf("missing coefficients for page type %s", pageTypes[pageType])
+		}
+		cs[pageType] = c
+	}
+
+	return nil
+}
+
+func (cs pageTypeCoefficients) MarshalJSON() ([]byte, error) {
+	byPageType := make(map[string]pageCoefficients, nPageTypes)
+	for pageType, c := range cs {
+		byPageType[pageTypes[pageTypeInt(pageType)]] = c
+	}
+	return json.Marshal(byPageType)
+}
+
+// An attentionModel predicts the share of sitewide upvotes that a story
+// receives at a given rank on each page type, and how upvote rates are
+// estimated from upvotes and expected upvotes. Models are identified by
+// name, which is recorded with every datapoint (see attention-models.go).
+type attentionModel struct {
+	Name          string               `json:"name"`
+	Coefficients  pageTypeCoefficients `json:"coefficients"`
+	FatigueFactor float64              `json:"fatigueFactor"`
+	PriorWeight   float64              `json:"priorWeight"`
+}
+
+// The model compiled into the binary, used unless ATTENTION_MODEL is set.
+var builtinAttentionModel = attentionModel{
+	Name:          "builtin",
+	Coefficients:  coefficients,
+	FatigueFactor: 0.003462767,
+	PriorWeight:   0.75,
+}
+
+// defaultAttentionModel is the model used for crawling and ranking.
+var defaultAttentionModel = builtinAttentionModel
+
+// setAttentionModel makes m the model used for crawling and ranking,
+// including the default fatigueFactor and priorWeight.
+func setAttentionModel(m attentionModel) {
+	defaultAttentionModel = m
+	defaultModelParams = m.modelParams()
+	defaultFrontPageParams.ModelParams = defaultModelParams
+}
+
+func (m attentionModel) modelParams() ModelParams {
+	return ModelParams{FatigueFactor: m.FatigueFactor, PriorWeight: m.PriorWeight}
+}
+
+type coefficientsRow struct {
+	PageType            string
+	PageTypeCoefficient float64
+	PageCoefficient     float64
+	RankCoefficient     float64
+}
+
+// CoefficientsTable lists the coefficients of the model by page type, for
+// display.
+func (m attentionModel) CoefficientsTable() []coefficientsRow {
+	rows := make([]coefficientsRow, nPageTypes)
+	for pageType, c := range m.Coefficients {
+		rows[pageType] = coefficientsRow{pageTypes[pageTypeInt(pageType)], c.pageTypeCoefficient, c.pageCoefficient, c.rankCoefficient}
+	}
+	return rows
+}
+
 type ModelParams struct {
 	FatigueFactor float64
 	PriorWeight   float64
@@ -66,17 +165,21 @@ func (p OptionalModelParams) WithDefaults() ModelParams {
 }
 
 // var defaultModelParams = ModelParams{0.003462767, 2.2956}
-var defaultModelParams = ModelParams{FatigueFactor: 0.003462767, PriorWeight: 0.75}
+var defaultModelParams = builtinAttentionModel.modelParams()
 
 func (p ModelParams) upvoteRate(upvotes int, expectedUpvotes float64) float64 {
 	return (float64(upvotes) + p.PriorWeight) / float64((1-math.Exp(-p.FatigueFactor*expectedUpvotes))/p.FatigueFactor+p.PriorWeight)
 }
 
 func expectedUpvoteShare(pageType pageTypeInt, oneBasedRank int) float64 {
+	return defaultAttentionModel.expectedUpvoteShare(pageType, oneBasedRank)
+}
+
+func (m attentionModel) expectedUpvoteShare(pageType pageTypeInt, oneBasedRank int) float64 {
 	zeroBasedPage := (oneBasedRank - 1) / 30
 	oneBasedRankOnPage := ((oneBasedRank - 1) % 30) + 1
 
-	cs := coefficients[pageType]
+	cs := m.Coefficients[pageType]
 
 	logExpectedUpvoteShare := cs.pageTypeCoefficient +
 		cs.pageCoefficient*math.Log(float64(zeroBasedPage+1)) +
@@ -88,6 +191,10 @@ func expectedUpvoteShare(pageType pageTypeInt, oneBasedRank int) float64 {
 var averageCrawlDelay = 10
 
 func expectedUpvoteShareNewPage(oneBasedRank, elapsedTime int, newRankChanges []int) float64 {
+	return defaultAttentionModel.expectedUpvoteShareNewPage(oneBasedRank, elapsedTime, newRankChanges)
+}
+
+func (m attentionModel) expectedUpvoteShareNewPage(oneBasedRank, elapsedTime int, newRankChanges []int) float64 {
 	rank := oneBasedRank
 	exUpvoteShare := 0.0
 
@@ -122,7 +229,28 @@ func expectedUpvoteShareNewPage(oneBasedRank, elapsedTime int, newRankChanges []
 			timeAtRank = current / 2
 		}
 
-		exUpvoteShare += expectedUpvoteShare(1, r) * float64(timeAtRank) / float64(elapsedTime)
+		exUpvoteShare += m.expectedUpvoteShare(new, r) * float64(timeAtRank) / float64(elapsedTime)
+	}
+
+	return exUpvoteShare
+}
+
+// expectedUpvoteShareForRanks is the total share of sitewide upvotes a story
+// is expected to receive given its ranks on all page types.
+func (m attentionModel) expectedUpvoteShareForRanks(ranks ranksArray, elapsedTime int, newRankChanges []int) float64 {
+	exUpvoteShare := 0.0
+
+	for pt, rank := range ranks {
+		pageType := pageTypeInt(pt)
+		if rank == 0 {
+			continue
+		}
+
+		if pageType == new && len(newRankChanges) > 0 {
+			exUpvoteShare += m.expectedUpvoteShareNewPage(rank, elapsedTime, newRankChanges)
+		} else {
+			exUpvoteShare += m.expectedUpvoteShare(pageType, rank)
+		}
 	}
 
 	return exUpvoteShare
@@ -1,6 +1,8 @@
 package main
 
-func getKeys[K comparable, V comparable](m map[K]V) []K {
+import "hash/fnv"
+
+func getKeys[K comparable, V any](m map[K]V) []K {
 	keys := make([]K, len(m))
 	var i int
 	for key := range m {
@@ -27,3 +29,9 @@ func mapSlice[T, U any](ts []T, f func(T) U) []U {
 	}
 	return results
 }
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return h.Sum32()
+}